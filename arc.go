@@ -11,178 +11,1094 @@ modified to make the O(n) list operations O(1).
 package arc
 
 import (
+	"errors"
 	"fmt"
-	"strings"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
+// ErrConcurrentModification is the panic value used when the cache is
+// modified while it is being iterated.
+var ErrConcurrentModification = errors.New("cache modified during iteration")
+
+// ErrPinned is returned when space is needed in the cache but every
+// candidate for eviction is pinned.
+var ErrPinned = errors.New("all cache entries are pinned")
+
+// ErrNoVerify is returned by Audit for a cache without a Verify callback.
+// StartAudit panics instead.
+var ErrNoVerify = errors.New("cache has no Verify callback")
+
+// ErrNotFound may be returned by a loader to report that a key definitely
+// does not exist, as opposed to a failure to look it up.
+var ErrNotFound = errors.New("key not found")
+
+// ErrSkipCache may be returned, possibly wrapped, by a loader along with
+// a valid value. The value is returned to the caller without an error but
+// is not cached, for results that are too large or known to be transient.
+var ErrSkipCache = errors.New("do not cache value")
+
+// LoadError is returned when a loader fails, it records the key being
+// loaded and wraps the loader's error.
+type LoadError[K comparable] struct {
+	Key K
+	Err error
+}
+
+func (e *LoadError[K]) Error() string {
+	return fmt.Sprintf("loading %v: %s", e.Key, e.Err)
+}
+
+func (e *LoadError[K]) Unwrap() error {
+	return e.Err
+}
+
 // Callbacks used by the cache to fill the cache.
 type Callbacks[K comparable, V any] struct {
 	// GetValue is called to retrieve a value from the cache.
-	// If it returns an error, the Get operation fails with an error.
+	// If it returns an error, the Get operation fails with a *LoadError
+	// wrapping it.
 	GetValue func(K) (V, error)
 	// OnEvict is called when a key is evicted from the cache.
 	// If it returns an error, the Get operation fails with an error.
 	OnEvict func(K, V) error
+	// OnExpire, if set, is called instead of OnEvict when a key is removed
+	// because it is stale or expired. Errors are handled as for OnEvict.
+	OnExpire func(K, V) error
+	// Verify is called by Audit and StartAudit to check a resident value
+	// against the origin. It returns false, ErrNotFound or ErrSkipCache if
+	// the value has diverged and must be reloaded.
+	Verify func(K, V) (bool, error)
+	// WriteValue enables write-back caching. Values stored with Set are
+	// marked dirty and written with WriteValue when they are evicted or
+	// flushed. If it returns an error, the operation fails with an error.
+	WriteValue func(K, V) error
+	// SetValue enables write-through caching. Set calls it before the
+	// value is cached, if it returns an error the Set fails and the cache
+	// is unchanged.
+	SetValue func(K, V) error
 }
 
 // Cache is a type implementing an Adaptive Replacement Cache,
 // it is NOT threadsafe without additional synchronization.
 type Cache[K comparable, V any] struct {
 	Callbacks Callbacks[K, V]
+	// Alarm optionally reports a degraded hit ratio.
+	Alarm HitRatioAlarm
+
+	data map[K]*entry[K, V]
+	// dirtyLen counts the entries marked dirty.
+	dirtyLen int
+	// entryPool recycles the entries of removed keys, so steady state
+	// churn does not allocate.
+	entryPool sync.Pool
+	// slab holds preallocated entries not yet used.
+	slab []entry[K, V]
+
+	cap           int
+	part          int
+	ghostRatio    float64
+	ghostCap      int
+	preallocate   bool
+	recoverPanics bool
+
+	t1 *entryList[K, V]
+	t2 *entryList[K, V]
+	b1 Ghosts[K]
+	b2 Ghosts[K]
+
+	newGhosts func() Ghosts[K]
+
+	victims victimCache[K, V]
+	groupOf func(K) any
+	isGroup func(any) bool
+	groups  map[any]map[K]struct{}
+	faults  *Faults[K]
+	logger  Logger
+	retries *evictionRetries[K, V]
+
+	evictPolicy EvictErrorPolicy
+
+	ops       *opRing[K]
+	onCorrupt func(*CorruptionError[K])
 
-	data map[K]V
+	listener  EventListener[K, V]
+	evictions chan EvictedEntry[K, V]
+	// demote, set by NewTiered, is called for values evicted to make
+	// space, but not for deleted or expired ones.
+	demote func(K, V) error
+	// evictionBuffer is the buffer size given to WithEvictionChannel.
+	evictionBuffer *int
+	// opID is the ID of the current operation, lastOpID the last assigned.
+	opID     uint64
+	lastOpID uint64
 
-	cap  int
-	part int
+	generation uint64
 
-	t1 *clist[K]
-	t2 *clist[K]
-	b1 *clist[K]
-	b2 *clist[K]
+	clock  Clock
+	expiry ExpiryPolicy[K, V]
+
+	expiryHeap expiryHeap[K]
+
+	trackStats bool
+	sizer      func(K, V) int
+
+	dependents   map[K]map[K]struct{}
+	dependencies map[K]map[K]struct{}
+
+	invalidateMu         sync.Mutex
+	invalidated          []K
+	pendingInvalidations atomic.Int32
+
+	maxLoads       int
+	loadSlots      chan struct{}
+	loadLimiter    Limiter
+	loadRetry      *LoadRetryPolicy
+	loadTimeout    time.Duration
+	staleOnTimeout bool
+
+	readahead func(K) []K
+	hintMu    sync.Mutex
+	hints     []K
+	// hintCap is the capacity as seen by Hint, which cannot read cap
+	// without the owner's lock.
+	hintCap int
+	hinted  chan struct{}
+
+	stats           Stats
+	window          Stats
+	degradedWindows int
+	recentWindow    int
+	recentAlpha     float64
+	recentHits      float64
+	recentWeight    float64
+	peak            int
+	peakSinceReset  int
+}
+
+// Config describes a cache to be created by NewWithConfig.
+type Config[K comparable, V any] struct {
+	// Size is the maximum number of entries held by the cache.
+	Size      int
+	Callbacks Callbacks[K, V]
+	Options   []Option[K, V]
 }
 
-func New[K comparable, V any](size int, callbacks Callbacks[K, V]) *Cache[K, V] {
+// New creates a cache holding up to size entries, optional behaviour
+// is configured by passing options. It panics if the configuration is
+// invalid, see NewWithConfig.
+func New[K comparable, V any](size int, callbacks Callbacks[K, V], opts ...Option[K, V]) *Cache[K, V] {
+	c, err := NewWithConfig(Config[K, V]{
+		Size:      size,
+		Callbacks: callbacks,
+		Options:   opts,
+	})
+	if err != nil {
+		panic(err)
+	}
+	return c
+}
+
+// NewWithConfig creates a cache, returning an error describing the
+// problem if the configuration is invalid.
+func NewWithConfig[K comparable, V any](cfg Config[K, V]) (*Cache[K, V], error) {
+	if cfg.Size < 1 {
+		return nil, fmt.Errorf("cache size must be positive, got %d", cfg.Size)
+	}
+	callbacks := cfg.Callbacks
 	if callbacks.GetValue == nil {
-		panic("expected a GetValue callback")
+		return nil, errors.New("expected a GetValue callback")
 	}
 	if callbacks.OnEvict == nil {
 		callbacks.OnEvict = func(K, V) error { return nil }
 	}
-	return &Cache[K, V]{
+	c := &Cache[K, V]{
 		Callbacks: callbacks,
-		data:      make(map[K]V),
-		cap:       size,
-		t1:        newClist[K](),
-		t2:        newClist[K](),
-		b1:        newClist[K](),
-		b2:        newClist[K](),
+		cap:       cfg.Size,
+	}
+	for _, opt := range cfg.Options {
+		opt(c)
+	}
+	if c.recoverPanics {
+		c.recoverCallbacks()
 	}
+	if c.ghostRatio == 0 {
+		c.ghostRatio = 1
+	}
+	if c.ghostRatio < 0 {
+		return nil, fmt.Errorf("ghost ratio must be positive, got %v", c.ghostRatio)
+	}
+	c.ghostCap = max(int(math.Round(c.ghostRatio*float64(c.cap))), 1)
+	c.data = make(map[K]*entry[K, V], c.cap)
+	if c.preallocate {
+		c.slab = make([]entry[K, V], c.cap)
+	}
+	c.hintCap = c.cap
+	c.hinted = make(chan struct{}, 1)
+	if c.listener == nil {
+		c.listener = NopListener[K, V]{}
+	}
+	if c.clock == nil {
+		c.clock = systemClock{}
+	}
+	c.t1 = newEntryList[K, V]()
+	c.t2 = newEntryList[K, V]()
+	if c.newGhosts != nil {
+		c.b1 = c.newGhosts()
+		c.b2 = c.newGhosts()
+	} else {
+		// Either ghost list can grow to the ghost capacity.
+		b1, b2 := newListGhosts[K](c.ghostCap), newListGhosts[K](c.ghostCap)
+		if c.preallocate {
+			b1.preallocate(c.ghostCap)
+			b2.preallocate(c.ghostCap)
+		}
+		c.b1, c.b2 = b1, b2
+	}
+	if c.evictPolicy == EvictErrorRetry && c.retries == nil {
+		c.retries = &evictionRetries[K, V]{
+			backoff:    defaultRetryBackoff,
+			maxBackoff: defaultRetryMaxBackoff,
+		}
+	}
+	if c.retries != nil && (c.retries.backoff <= 0 || c.retries.maxBackoff < c.retries.backoff) {
+		return nil, fmt.Errorf("eviction retry backoff must be positive and at most the maximum, got %v and %v", c.retries.backoff, c.retries.maxBackoff)
+	}
+	if c.recentWindow == 0 {
+		c.recentWindow = defaultHitRatioWindow
+	}
+	if c.recentWindow < 1 {
+		return nil, fmt.Errorf("hit ratio window must be positive, got %d", c.recentWindow)
+	}
+	c.recentAlpha = 1 / float64(c.recentWindow)
+	if c.maxLoads < 0 {
+		return nil, fmt.Errorf("maximum concurrent loads must not be negative, got %d", c.maxLoads)
+	}
+	if c.loadTimeout < 0 {
+		return nil, fmt.Errorf("load timeout must not be negative, got %v", c.loadTimeout)
+	}
+	if c.loadRetry != nil {
+		err := c.loadRetry.validate()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if c.maxLoads > 0 {
+		c.loadSlots = make(chan struct{}, c.maxLoads)
+	}
+	if c.victims.size < 0 {
+		return nil, fmt.Errorf("victim cache size must not be negative, got %d", c.victims.size)
+	}
+	if c.evictionBuffer != nil {
+		if *c.evictionBuffer < 0 {
+			return nil, fmt.Errorf("eviction channel buffer must not be negative, got %d", *c.evictionBuffer)
+		}
+		c.evictions = make(chan EvictedEntry[K, V], *c.evictionBuffer)
+	}
+	if c.Alarm.OnDegraded != nil {
+		if c.Alarm.Window < 1 {
+			return nil, fmt.Errorf("hit ratio alarm window must be positive, got %d", c.Alarm.Window)
+		}
+		if c.Alarm.MinRatio < 0 || c.Alarm.MinRatio > 1 {
+			return nil, fmt.Errorf("hit ratio alarm minimum must be between 0 and 1, got %v", c.Alarm.MinRatio)
+		}
+	}
+	return c, nil
+}
+
+// victim returns the least recently used unpinned entry of t, or nil.
+func (c *Cache[K, V]) victim(t *entryList[K, V]) *entry[K, V] {
+	for e := t.Back(); e != nil; e = e.preceding() {
+		if e.pins == 0 {
+			return e
+		}
+	}
+	return nil
 }
 
+// replace makes room for key if the cache is full. In plain ARC the cache
+// is always full once ghosts exist, but Delete and Trim can leave room.
 func (c *Cache[K, V]) replace(key K, part int) error {
-	var t, b *clist[K]
-	if (c.t1.Len() > 0 && c.b2.Has(key) && c.t1.Len() == part) || (c.t1.Len() > part) {
-		t = c.t1
-		b = c.b1
-	} else {
-		t = c.t2
-		b = c.b2
+	if c.t1.Len()+c.t2.Len() < c.cap {
+		return nil
+	}
+	fromT1 := (c.t1.Len() > 0 && c.b2.Contains(key) && c.t1.Len() == part) || (c.t1.Len() > part)
+	_, _, err := c.evict(fromT1)
+	return err
+}
+
+// evict moves the least recently used unpinned entry of t1 or t2 into the
+// matching ghost list, falling back to the other list if needed.
+func (c *Cache[K, V]) evict(fromT1 bool) (K, V, error) {
+	t, b := c.t2, c.b2
+	ot, ob := c.t1, c.b1
+	if fromT1 {
+		t, b, ot, ob = ot, ob, t, b
+	}
+	e := c.victim(t)
+	if e == nil {
+		// Everything in the preferred list is pinned, fall back to the other.
+		t, b = ot, ob
+		e = c.victim(t)
+		if e == nil {
+			var zeroK K
+			var zeroV V
+			return zeroK, zeroV, ErrPinned
+		}
+	}
+	old, value := e.key, e.value
+	if c.data[old] != e {
+		return old, value, c.corrupt("%v is listed but has no value", old)
 	}
-	old := t.Last()
-	err := c.Callbacks.OnEvict(old, c.data[old])
+	c.recordOp("evict", old)
+	err := c.releaseEvicted(old, value)
 	if err != nil {
-		return err
+		return old, value, err
+	}
+	t.Remove(e)
+	b.Add(old)
+	c.pushVictim(e)
+	c.unstore(old)
+	c.logEvict(old)
+	c.listener.OnEvict(old, value)
+	c.sendEviction(old, value)
+	return old, value, nil
+}
+
+// releaseEvicted is release for a value evicted to make space, which is
+// first demoted if the cache has a demote hook. A dirty value reaches the
+// same place when it is written back, so it is not demoted as well.
+func (c *Cache[K, V]) releaseEvicted(key K, value V) error {
+	if c.demote != nil {
+		if e := c.data[key]; e == nil || !e.dirty {
+			err := c.demote(key, value)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return c.release(key, value, false)
+}
+
+// release writes back a dirty value and then calls OnEvict, or OnExpire
+// if it expired, for it.
+func (c *Cache[K, V]) release(key K, value V, expired bool) error {
+	if e := c.data[key]; e != nil && e.dirty {
+		err := c.Callbacks.WriteValue(key, value)
+		if err != nil {
+			return err
+		}
+		c.setDirty(e, false)
+	}
+	err := c.notifyEvict(key, value, expired)
+	if err != nil {
+		return c.evictFailed(key, value, expired, err)
 	}
-	t.Pop()
-	b.PushFront(old)
-	delete(c.data, old)
 	return nil
 }
 
 func (c *Cache[K, V]) Get(key K) (V, error) {
+	return c.get(key, c.guardLoader(c.Callbacks.GetValue), GetOptions{})
+}
+
+// GetOptions adjusts the behaviour of a single lookup, see GetWithOptions.
+type GetOptions struct {
+	// ForceRefresh ignores any cached value and calls the loader, the
+	// result replaces the cached value if there is one. A dirty value,
+	// set but not yet written back, is newer than the origin, so it is
+	// kept and returned instead.
+	ForceRefresh bool
+	// SkipCache returns a loaded value without inserting it.
+	SkipCache bool
+	// NoPromote does not count a hit as an access, so the entry keeps its
+	// position in the cache, and its idle expiry and entry stats are
+	// unchanged.
+	NoPromote bool
+}
+
+// GetWithOptions is like Get, adjusted by opts.
+func (c *Cache[K, V]) GetWithOptions(key K, opts GetOptions) (V, error) {
+	return c.get(key, c.guardLoader(c.Callbacks.GetValue), opts)
+}
+
+// Refresh calls the GetValue callback for key and caches the result,
+// replacing any cached value without changing its position in the cache.
+// If the load fails the cached value is left in place. A dirty value is
+// newer than the origin, so it is kept and returned without being replaced.
+func (c *Cache[K, V]) Refresh(key K) (V, error) {
+	return c.get(key, c.guardLoader(c.Callbacks.GetValue), GetOptions{ForceRefresh: true, NoPromote: true})
+}
+
+// GetWithLoader is like Get, but calls loader instead of the GetValue
+// callback if the key is not cached.
+func (c *Cache[K, V]) GetWithLoader(key K, loader func(K) (V, error)) (V, error) {
+	return c.get(key, c.guardLoader(loader), GetOptions{})
+}
+
+// GetOrCompute is like Get, but calls compute instead of the GetValue
+// callback if the key is not cached. It allows the cache to be used as a
+// memoizer without a global loader.
+func (c *Cache[K, V]) GetOrCompute(key K, compute func() (V, error)) (V, error) {
+	return c.get(key, c.guardLoader(func(K) (V, error) { return compute() }), GetOptions{})
+}
+
+func (c *Cache[K, V]) get(key K, loader func(K) (V, error), opts GetOptions) (V, error) {
+	op := c.beginOp()
+	c.recordOp("get", key)
+	err := c.applyInvalidations()
+	if err == nil && !c.keepForTimeout(key) {
+		err = c.dropStale(key)
+	}
+	if err != nil {
+		var zero V
+		return zero, err
+	}
+
+	if !opts.ForceRefresh {
+		var e *entry[K, V]
+		if opts.NoPromote {
+			e = c.lookup(key)
+		} else {
+			e = c.hit(key)
+		}
+		if e != nil {
+			if !opts.NoPromote {
+				c.accessed(e)
+			}
+			c.recordLookup(true)
+			c.listener.OnHit(key, e.value)
+			return e.value, nil
+		}
+
+		c.recordLookup(false)
+		c.listener.OnMiss(key)
+		if c.readahead != nil {
+			c.Hint(c.readahead(key)...)
+		}
+
+		if !opts.SkipCache {
+			if result, ok, err := c.recoverVictim(key); ok || err != nil {
+				return result, err
+			}
+		}
+	}
+
+	if c.recoverPanics {
+		loader = recoverLoader(loader)
+	}
+	result, err := c.faultyLoader(loader)(key)
+	// GetAsync releases the lock while loading, so other operations may
+	// have run.
+	c.opID = op
+	if errors.Is(err, ErrSkipCache) {
+		return result, nil
+	}
+	if err != nil {
+		c.logLoadError(key, err)
+		if c.staleOnTimeout && errors.Is(err, ErrLoadTimeout) {
+			// Resident and not invalidated, but perhaps expired.
+			if e, ok := c.data[key]; ok && e.born == c.generation {
+				return e.value, nil
+			}
+		}
+		return result, &LoadError[K]{Key: key, Err: err}
+	}
+	if opts.SkipCache {
+		return result, nil
+	}
+
+	if e, ok := c.data[key]; ok {
+		if c.stale(e) {
+			// Pinned, so dropStale left it to be renewed.
+			return result, c.renew(e, result)
+		}
+		if !opts.ForceRefresh || e.dirty {
+			// Cached while GetAsync released the lock to load, or set and
+			// not yet written back, either way the resident value is newer.
+			return e.value, nil
+		}
+		// A forced refresh of a resident key.
+		c.victims.take(key)
+		if !opts.NoPromote {
+			c.hit(key)
+		}
+		old := e.value
+		e.value = result
+		c.expireUpdated(e)
+		c.listener.OnUpdate(key, old, result)
+		return result, nil
+	}
+
+	err = c.admit(key, result)
+	return result, err
+}
+
+// Set stores a value in the cache as if it had been accessed. When the
+// SetValue callback is set the value is first written through to it. When
+// the WriteValue callback is set the entry is marked dirty and written back
+// later. OnEvict is not called for a value replaced by Set.
+func (c *Cache[K, V]) Set(key K, value V) error {
+	c.beginOp()
+	c.recordOp("set", key)
+	err := c.applyInvalidations()
+	if err == nil {
+		err = c.dropStale(key)
+	}
+	if err != nil {
+		return err
+	}
+	if c.Callbacks.SetValue != nil {
+		err = c.Callbacks.SetValue(key, value)
+		if err != nil {
+			return err
+		}
+	}
+	c.victims.take(key)
+	if e, ok := c.data[key]; ok && c.stale(e) {
+		err = c.renew(e, value)
+		if err != nil {
+			return err
+		}
+	} else if e := c.hit(key); e != nil {
+		old := e.value
+		e.value = value
+		c.expireUpdated(e)
+		c.listener.OnUpdate(key, old, value)
+	} else {
+		err = c.admit(key, value)
+		if err != nil {
+			return err
+		}
+	}
+	if c.Callbacks.WriteValue != nil {
+		c.setDirty(c.data[key], true)
+	}
+	return nil
+}
+
+// Update replaces the value for key with the result of fn. The value is
+// found or loaded as Get would, and passed to fn with true. If the loader
+// returns ErrNotFound fn receives the zero value and false instead. The
+// result is stored as if by Set. If the load or fn fails the cache is
+// unchanged and the error is returned.
+func (c *Cache[K, V]) Update(key K, fn func(old V, exists bool) (V, error)) error {
+	old, err := c.get(key, c.guardLoader(c.Callbacks.GetValue), GetOptions{SkipCache: true})
+	exists := err == nil
+	if errors.Is(err, ErrNotFound) {
+		err = nil
+	}
+	if err != nil {
+		return err
+	}
+	return c.updateWith(key, old, exists, fn)
+}
+
+// updateCached is Update without loading, fn is only given a cached value.
+func (c *Cache[K, V]) updateCached(key K, fn func(old V, exists bool) (V, error)) error {
+	err := c.applyInvalidations()
+	if err == nil {
+		err = c.dropStale(key)
+	}
+	if err != nil {
+		return err
+	}
+	old, exists := c.peek(key)
+	return c.updateWith(key, old, exists, fn)
+}
+
+func (c *Cache[K, V]) updateWith(key K, old V, exists bool, fn func(old V, exists bool) (V, error)) error {
+	value, err := fn(old, exists)
+	if err != nil {
+		return err
+	}
+	return c.Set(key, value)
+}
+
+// Replace stores value as if by Set, but only if key is already cached.
+// It returns whether the value was replaced.
+func (c *Cache[K, V]) Replace(key K, value V) (bool, error) {
+	err := c.applyInvalidations()
+	if err == nil {
+		err = c.dropStale(key)
+	}
+	if err != nil {
+		return false, err
+	}
+	if c.lookup(key) == nil {
+		return false, nil
+	}
+	err = c.Set(key, value)
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// CompareAndSwap stores new for key as if by Set, but only if key is cached
+// with a value equal to old. It returns false if the value did not match or
+// the Set failed.
+func CompareAndSwap[K comparable, V comparable](c *Cache[K, V], key K, old, new V) bool {
+	if c.applyInvalidations() != nil || c.dropStale(key) != nil {
+		return false
+	}
+	current, ok := c.peek(key)
+	if !ok || current != old {
+		return false
+	}
+	return c.Set(key, new) == nil
+}
+
+// Touch records an access to key as Get would, without returning the
+// value or calling the loader. It returns false if the key is not cached.
+func (c *Cache[K, V]) Touch(key K) bool {
+	if c.applyInvalidations() != nil || c.dropStale(key) != nil {
+		return false
+	}
+	e := c.hit(key)
+	if e != nil {
+		c.accessed(e)
+	}
+	return e != nil
+}
+
+// KV is a key and value pair.
+type KV[K comparable, V any] struct {
+	Key   K
+	Value V
+}
 
-	if elt := c.t1.Lookup(key); elt != nil {
-		c.t1.Remove(key, elt)
-		c.t2.PushFront(key)
-		return c.data[key], nil
+// SetMany calls Set for each entry in order, stopping at the first error,
+// and returns how many entries were set. It is not all or nothing, entries
+// before the failing one stay set. Entries beyond the capacity of the cache
+// evict earlier ones as Set would.
+func (c *Cache[K, V]) SetMany(entries []KV[K, V]) (int, error) {
+	for i, kv := range entries {
+		err := c.Set(kv.Key, kv.Value)
+		if err != nil {
+			return i, err
+		}
 	}
+	return len(entries), nil
+}
 
-	if elt := c.t2.Lookup(key); elt != nil {
-		c.t2.MoveToFront(elt)
-		return c.data[key], nil
+// Flush writes back all dirty entries, leaving them in the cache. It takes
+// time proportional to the number of entries if any are dirty.
+func (c *Cache[K, V]) Flush() error {
+	if c.dirtyLen == 0 {
+		return nil
+	}
+	for key, e := range c.data {
+		if !e.dirty {
+			continue
+		}
+		err := c.FlushKey(key)
+		if err != nil {
+			return err
+		}
 	}
+	return nil
+}
 
-	result, err := c.Callbacks.GetValue(key)
+// FlushKey writes back key if it is dirty, leaving it in the cache.
+func (c *Cache[K, V]) FlushKey(key K) error {
+	e, ok := c.data[key]
+	if !ok || !e.dirty {
+		return nil
+	}
+	err := c.Callbacks.WriteValue(key, e.value)
 	if err != nil {
-		return result, err
+		return err
+	}
+	c.setDirty(e, false)
+	return nil
+}
+
+// setDirty marks e as needing to be written back, or not.
+func (c *Cache[K, V]) setDirty(e *entry[K, V], dirty bool) {
+	if e.dirty == dirty {
+		return
+	}
+	e.dirty = dirty
+	if dirty {
+		c.dirtyLen += 1
+	} else {
+		c.dirtyLen -= 1
+	}
+}
+
+// store records a newly resident key and its value at the front of t.
+func (c *Cache[K, V]) store(t *entryList[K, V], key K, value V) {
+	e, _ := c.entryPool.Get().(*entry[K, V])
+	if e == nil && len(c.slab) != 0 {
+		e = &c.slab[0]
+		c.slab = c.slab[1:]
+	}
+	if e == nil {
+		e = &entry[K, V]{}
+	}
+	e.key, e.value, e.born = key, value, c.generation
+	t.PushFront(e)
+	c.data[key] = e
+	if c.groupOf != nil {
+		c.joinGroup(key)
+	}
+	if c.expiry != nil {
+		c.expireCreated(e)
+	}
+	if c.trackStats {
+		c.added(e)
+	}
+	c.recordLen()
+	c.listener.OnAdd(key, value)
+}
+
+// unstore forgets a key that is no longer resident.
+func (c *Cache[K, V]) unstore(key K) {
+	if e, ok := c.data[key]; ok {
+		delete(c.data, key)
+		if e.expiry != nil {
+			c.forgetExpiry(e)
+		}
+		// Clear the entry so the pool does not keep its value alive.
+		*e = entry[K, V]{}
+		c.entryPool.Put(e)
+	}
+	if c.groupOf != nil {
+		c.leaveGroup(key)
+	}
+	if c.dependencies != nil {
+		c.forgetDependencies(key)
+	}
+}
+
+// lookup returns the entry of a resident key, or nil if it is not
+// resident or is stale.
+func (c *Cache[K, V]) lookup(key K) *entry[K, V] {
+	e, ok := c.data[key]
+	if !ok || c.stale(e) {
+		return nil
+	}
+	return e
+}
+
+// peek returns the value of a resident key without counting an access.
+func (c *Cache[K, V]) peek(key K) (V, bool) {
+	if e := c.lookup(key); e != nil {
+		return e.value, true
+	}
+	var zero V
+	return zero, false
+}
+
+// hit promotes a resident key as an access would and returns its entry,
+// or nil if it is not resident or is stale.
+func (c *Cache[K, V]) hit(key K) *entry[K, V] {
+	e := c.lookup(key)
+	if e != nil {
+		switch e.list {
+		case c.t1:
+			c.t1.Remove(e)
+			c.t2.PushFront(e)
+			return e
+		case c.t2:
+			c.t2.MoveToFront(e)
+			return e
+		}
 	}
+	return nil
+}
 
-	if elt := c.b1.Lookup(key); elt != nil {
+// admit inserts a key that is not resident, evicting as needed.
+// On error the cache is left unchanged.
+func (c *Cache[K, V]) admit(key K, result V) error {
+
+	if c.b1.Contains(key) {
 		part := min(c.cap, c.part+max(c.b2.Len()/c.b1.Len(), 1))
 		err := c.replace(key, part)
 		if err != nil {
-			return result, err
+			return err
 		}
 		c.part = part
-		c.b1.Remove(key, elt)
-		c.t2.PushFront(key)
-		c.data[key] = result
-		return result, nil
+		c.stats.B1Hits += 1
+		c.window.B1Hits += 1
+		c.b1.Remove(key)
+		c.store(c.t2, key, result)
+		return nil
 	}
 
-	if elt := c.b2.Lookup(key); elt != nil {
+	if c.b2.Contains(key) {
 		part := max(0, c.part-max(c.b1.Len()/c.b2.Len(), 1))
 		err := c.replace(key, part)
 		if err != nil {
-			return result, err
+			return err
 		}
 		c.part = part
-		c.b2.Remove(key, elt)
-		c.t2.PushFront(key)
-		c.data[key] = result
-		return result, nil
+		c.stats.B2Hits += 1
+		c.window.B2Hits += 1
+		c.b2.Remove(key)
+		c.store(c.t2, key, result)
+		return nil
 	}
 
-	if c.t1.Len()+c.b1.Len() == c.cap {
-		if c.t1.Len() < c.cap {
+	// With the default ghost ratio these bounds are the usual ARC bounds
+	// of c for T1 and B1 together, and 2c for all four lists.
+	if c.t1.Len()+c.b1.Len() >= max(c.cap, c.ghostCap) {
+		if c.b1.Len() > 0 {
 			err := c.replace(key, c.part)
 			if err != nil {
-				return result, err
+				return err
 			}
-			c.b1.Pop()
+			c.b1.RemoveOldest()
 		} else {
-			pop := c.t1.Last()
-			err := c.Callbacks.OnEvict(pop, c.data[pop])
+			e := c.victim(c.t1)
+			if e == nil {
+				return ErrPinned
+			}
+			pop, value := e.key, e.value
+			err := c.releaseEvicted(pop, value)
 			if err != nil {
-				return result, err
+				return err
 			}
-			c.t1.Pop()
-			delete(c.data, pop)
+			c.t1.Remove(e)
+			c.pushVictim(e)
+			c.unstore(pop)
+			c.logEvict(pop)
+			c.listener.OnEvict(pop, value)
+			c.sendEviction(pop, value)
 		}
 	} else {
 		total := c.t1.Len() + c.b1.Len() + c.t2.Len() + c.b2.Len()
 		if total >= c.cap {
-			if total == (2 * c.cap) {
-				removed := c.b2.Pop()
+			if total >= c.cap+c.ghostCap {
+				// Choose the ghost list before replace adds to it, its
+				// oldest ghost is unaffected by replace, so it is safe to
+				// drop it afterwards and avoid any rollback.
+				b := c.b2
+				if b.Len() == 0 {
+					b = c.b1
+				}
 				err := c.replace(key, c.part)
 				if err != nil {
-					// Rollback removal.
-					c.b2.PushBack(removed)
-					return result, err
+					return err
 				}
+				b.RemoveOldest()
 			} else {
 				err := c.replace(key, c.part)
 				if err != nil {
-					return result, err
+					return err
 				}
 			}
 		}
 	}
 
-	c.t1.PushFront(key)
-	c.data[key] = result
+	c.store(c.t1, key, result)
+
+	return nil
+}
+
+// EvictOldest evicts the entry the cache would choose if it needed space,
+// returning it and true, or false if the cache holds no entries.
+func (c *Cache[K, V]) EvictOldest() (K, V, bool, error) {
+	c.beginOp()
+	return c.evictOldest()
+}
+
+func (c *Cache[K, V]) evictOldest() (K, V, bool, error) {
+	if len(c.data) == 0 {
+		var zeroK K
+		var zeroV V
+		return zeroK, zeroV, false, nil
+	}
+	key, value, err := c.evict(c.t1.Len() > c.part)
+	if err != nil {
+		return key, value, false, err
+	}
+	return key, value, true, nil
+}
+
+// Trim evicts up to n entries as if by repeated calls to EvictOldest.
+func (c *Cache[K, V]) Trim(n int) error {
+	c.beginOp()
+	for ; n > 0; n -= 1 {
+		_, _, ok, err := c.evictOldest()
+		if err != nil {
+			return err
+		}
+		if !ok {
+			break
+		}
+	}
+	return nil
+}
+
+// Compact rebuilds the internal maps of the cache. Go maps do not shrink
+// when entries are removed, so a long running cache with heavy churn can
+// call Compact to release that memory. It takes time proportional to the
+// number of keys tracked.
+func (c *Cache[K, V]) Compact() {
+	c.data = compactMap(c.data)
+	if c.groups != nil {
+		groups := make(map[any]map[K]struct{}, len(c.groups))
+		for g, members := range c.groups {
+			groups[g] = compactMap(members)
+		}
+		c.groups = groups
+	}
+	c.dependents = compactSets(c.dependents)
+	c.dependencies = compactSets(c.dependencies)
+	for _, g := range [...]Ghosts[K]{c.b1, c.b2} {
+		if compacter, ok := g.(interface{ Compact() }); ok {
+			compacter.Compact()
+		}
+	}
+}
+
+func compactMap[K comparable, V any](m map[K]V) map[K]V {
+	compacted := make(map[K]V, len(m))
+	for k, v := range m {
+		compacted[k] = v
+	}
+	return compacted
+}
+
+// compactSets compacts a map of sets and each of the sets.
+func compactSets[K comparable](m map[K]map[K]struct{}) map[K]map[K]struct{} {
+	if m == nil {
+		return nil
+	}
+	compacted := make(map[K]map[K]struct{}, len(m))
+	for k, set := range m {
+		compacted[k] = compactMap(set)
+	}
+	return compacted
+}
 
-	return result, nil
+// Pin prevents a resident key from being evicted until a matching call to
+// Unpin. Pins are counted, so a key pinned twice must be unpinned twice.
+// Pin returns false if the key is not in the cache or is stale. Pins do not
+// stop an entry going stale, see ExpiryPolicy and BumpGeneration.
+func (c *Cache[K, V]) Pin(key K) bool {
+	if c.applyInvalidations() != nil || c.dropStale(key) != nil {
+		return false
+	}
+	e := c.lookup(key)
+	if e == nil {
+		return false
+	}
+	e.pins += 1
+	return true
 }
 
-func (c *Cache[K, V]) DebugDump() string {
-	var sb strings.Builder
+// Unpin releases a pin taken by Pin.
+func (c *Cache[K, V]) Unpin(key K) {
+	if e, ok := c.data[key]; ok && e.pins > 0 {
+		e.pins -= 1
+	}
+}
 
-	fmt.Fprintf(&sb, "Cache DebugDump:\n")
-	fmt.Fprintf(&sb, "  data: %v\n", c.data)
-	fmt.Fprintf(&sb, "  cap: %d\n", c.cap)
-	fmt.Fprintf(&sb, "  part: %d\n", c.part)
+// Delete removes key from the cache, writing it back if dirty and calling
+// OnEvict for it. Unlike an eviction, the key is not remembered in the ghost
+// lists. It returns false if the key was not cached, or ErrPinned if the
+// key is pinned.
+func (c *Cache[K, V]) Delete(key K) (bool, error) {
+	c.beginOp()
+	err := c.applyInvalidations()
+	if err != nil {
+		return false, err
+	}
+	return c.remove(key)
+}
 
-	fmt.Fprintf(&sb, "  t1:\n")
-	sb.WriteString(c.t1.DebugDump())
-	fmt.Fprintf(&sb, "  t2:\n")
-	sb.WriteString(c.t2.DebugDump())
-	fmt.Fprintf(&sb, "  b1:\n")
-	sb.WriteString(c.b1.DebugDump())
-	fmt.Fprintf(&sb, "  b2:\n")
-	sb.WriteString(c.b2.DebugDump())
+func (c *Cache[K, V]) remove(key K) (bool, error) {
+	return c.removeEntry(key, false)
+}
 
-	return sb.String()
+// removeEntry deletes a resident key, expired selects which event is sent.
+func (c *Cache[K, V]) removeEntry(key K, expired bool) (bool, error) {
+	// A deleted value must not be recovered either.
+	c.victims.take(key)
+	e, ok := c.data[key]
+	if !ok {
+		return false, nil
+	}
+	if e.pins > 0 {
+		return false, ErrPinned
+	}
+	if e.list == nil {
+		return false, c.corrupt("%v has a value but is not listed", key)
+	}
+	value := e.value
+	c.recordOp("delete", key)
+	err := c.release(key, value, expired)
+	if err != nil {
+		return false, err
+	}
+	e.list.Remove(e)
+	c.unstore(key)
+	if expired {
+		c.listener.OnExpire(key, value)
+	} else {
+		c.listener.OnEvict(key, value)
+	}
+	c.sendEviction(key, value)
+	if c.dependents != nil {
+		return true, c.removeDependents(key, expired)
+	}
+	return true, nil
+}
+
+// DeleteWhere deletes every cached entry for which pred returns true, as if
+// by Delete. It stops at the first error, returning how many entries were
+// deleted.
+func (c *Cache[K, V]) DeleteWhere(pred func(K, V) bool) (int, error) {
+	c.beginOp()
+	err := c.applyInvalidations()
+	if err != nil {
+		return 0, err
+	}
+	c.victims.dropWhere(pred)
+	matched := []K{}
+	for key, e := range c.data {
+		if pred(key, e.value) {
+			matched = append(matched, key)
+		}
+	}
+	deleted := 0
+	for _, key := range matched {
+		_, err := c.remove(key)
+		if err != nil {
+			return deleted, err
+		}
+		deleted += 1
+	}
+	return deleted, nil
+}
+
+// Range calls fn for each cached entry until fn returns false. Frequently
+// used entries come first, then recently used entries, each from most to
+// least recently used. Visiting an entry does not count as an access.
+//
+// The cache must not be modified during iteration, Range panics with
+// ErrConcurrentModification if it detects that it was.
+func (c *Cache[K, V]) Range(fn func(K, V) bool) {
+	// Entries that fail to invalidate are still visited.
+	_ = c.applyInvalidations()
+	gen1, gen2 := c.t1.gen, c.t2.gen
+	for _, l := range [...]*entryList[K, V]{c.t2, c.t1} {
+		for e := l.Front(); e != nil; e = e.following() {
+			if c.stale(e) {
+				continue
+			}
+			if !fn(e.key, e.value) {
+				return
+			}
+			if c.t1.gen != gen1 || c.t2.gen != gen2 {
+				panic(ErrConcurrentModification)
+			}
+		}
+	}
 }
 
 func min(x, y int) int {
@@ -1,12 +1,23 @@
 package arc
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
 	"encoding/binary"
 	"errors"
+	"fmt"
 	"math/rand"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
-	"github.com/andrewchambers/list-go"
+	"github.com/andrewchambers/arc-go/fakeclock"
 )
 
 func TestARCBlackBox(t *testing.T) {
@@ -48,6 +59,7 @@ func TestARCBlackBox(t *testing.T) {
 				if v != x {
 					t.Fatal("bad value")
 				}
+				checkInvariants(t, cache)
 				break
 			}
 		}
@@ -74,22 +86,3295 @@ func TestARCInternal(t *testing.T) {
 		cache.Get(string(b[:]))
 	}
 
-	checkList(t, "t1", cache.t1.l, []byte{41})
-	checkList(t, "t2", cache.t2.l, []byte{11, 17, 16, 32, 33, 34, 35, 36, 37})
-	checkList(t, "b1", cache.b1.l, []byte{31, 30})
-	checkList(t, "b2", cache.b2.l, []byte{38, 39, 19, 18, 15, 14, 13, 12})
+	state := cache.Inspect()
 
-	if cache.part != 5 {
-		t.Errorf("bad p: got=%v want=5", cache.part)
+	checkList(t, "t1", state.T1, []byte{41})
+	checkList(t, "t2", state.T2, []byte{11, 17, 16, 32, 33, 34, 35, 36, 37})
+	checkList(t, "b1", state.B1, []byte{31, 30})
+	checkList(t, "b2", state.B2, []byte{38, 39, 19, 18, 15, 14, 13, 12})
+
+	if state.Partition != 5 {
+		t.Errorf("bad p: got=%v want=5", state.Partition)
+	}
+	if cache.T1Len() != 1 || cache.T2Len() != 9 || cache.B1Len() != 2 || cache.B2Len() != 8 {
+		t.Errorf("bad list lengths: %d %d %d %d", cache.T1Len(), cache.T2Len(), cache.B1Len(), cache.B2Len())
+	}
+}
+
+func TestCompact(t *testing.T) {
+
+	cache := NewManual(10,
+		WithGroups[int, int](func(k int) int { return k % 2 }),
+		WithPreallocatedEntries[int, int](),
+	)
+	c := cache.Cache()
+	for k := 0; k < 100; k += 1 {
+		cache.Set(k, k)
+	}
+	for k := 90; k < 95; k += 1 {
+		cache.AddDependency(k+5, k)
+	}
+	inspected := c.Inspect()
+
+	// Compaction must not change behaviour.
+	c.Compact()
+	checkInvariants(t, c)
+	after := c.Inspect()
+	if fmt.Sprint(after) != fmt.Sprint(inspected) {
+		t.Fatalf("compaction changed the cache: %v, was %v", after, inspected)
+	}
+	if len(c.groups[0]) != 5 || len(c.groups[1]) != 5 {
+		t.Fatalf("bad group index: %v", c.groups)
+	}
+	if len(c.dependents) != 5 || len(c.dependencies) != 5 {
+		t.Fatalf("bad dependency index: %v %v", c.dependents, c.dependencies)
+	}
+
+	// The rebuilt indexes are still used.
+	n, err := cache.Delete(90)
+	if err != nil || !n {
+		t.Fatalf("delete failed: %v", err)
+	}
+	if cache.Contains(95) {
+		t.Fatal("dependent survived deleting its parent after compaction")
+	}
+	deleted, err := c.InvalidateGroup(1)
+	if err != nil || deleted != 4 {
+		t.Fatalf("expected 4 odd keys invalidated, got %d, %v", deleted, err)
+	}
+	checkInvariants(t, c)
+
+	// Preallocated entries not yet used are kept.
+	cache = NewManual(10, WithPreallocatedEntries[int, int]())
+	cache.Set(1, 1)
+	cache.Cache().Compact()
+	if len(cache.Cache().slab) != 9 || len(cache.Cache().b1.(*listGhosts[int]).slab) != 10 {
+		t.Fatal("compaction dropped the preallocated slabs")
+	}
+}
+
+func TestAudit(t *testing.T) {
+
+	origin := map[int]int{}
+	errVerify := errors.New("verify failed")
+
+	cache := New[int, int](10, Callbacks[int, int]{
+		GetValue: func(k int) (int, error) { return origin[k], nil },
+		Verify: func(k, v int) (bool, error) {
+			switch k {
+			case 8:
+				return false, ErrNotFound
+			case 9:
+				return false, errVerify
+			}
+			return origin[k] == v, nil
+		},
+	}, WithEvictionChannel[int, int](10))
+
+	for i := 0; i < 10; i += 1 {
+		origin[i] = i
+		cache.Get(i)
+	}
+	// Pinned entries are not checked.
+	cache.Pin(9)
+
+	origin[3] = 33
+	origin[7] = 77
+
+	repaired, err := cache.Audit(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if repaired != 3 {
+		t.Fatalf("bad repair count: got=%d want=3", repaired)
+	}
+	// Diverged entries leave as if deleted.
+	evicted := map[int]int{}
+	for len(cache.Evictions()) != 0 {
+		e := <-cache.Evictions()
+		evicted[e.Key] = e.Value
+	}
+	if fmt.Sprint(evicted) != "map[3:3 7:7 8:8]" {
+		t.Fatalf("bad evictions: %v", evicted)
+	}
+	for k, want := range origin {
+		v, _ := cache.Get(k)
+		if v != want {
+			t.Fatalf("bad value for %d: got=%d want=%d", k, v, want)
+		}
+	}
+	cache.Unpin(9)
+	_, err = cache.Audit(10)
+	if !errors.Is(err, errVerify) {
+		t.Fatalf("expected the Verify error, got %v", err)
+	}
+	checkInvariants(t, cache)
+
+	unverified := New[int, int](10, Callbacks[int, int]{
+		GetValue: func(k int) (int, error) { return k, nil },
+	})
+	unverified.Get(1)
+	repaired, err = unverified.Audit(10)
+	if err != ErrNoVerify || repaired != 0 {
+		t.Fatalf("expected ErrNoVerify, got %d %v", repaired, err)
+	}
+}
+
+func TestStartAudit(t *testing.T) {
+
+	mu := &sync.Mutex{}
+	origin := map[int]int{}
+	cache := New[int, int](10, Callbacks[int, int]{
+		GetValue: func(k int) (int, error) { return origin[k], nil },
+		Verify: func(k, v int) (bool, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			return origin[k] == v, nil
+		},
+		WriteValue: func(k, v int) error { origin[k] = v; return nil },
+	})
+
+	mu.Lock()
+	for i := 0; i < 10; i += 1 {
+		origin[i] = i
+		cache.Get(i)
+	}
+	origin[2] = 22
+	origin[5] = 55
+	// A dirty value is newer than the origin and is left alone.
+	cache.Set(6, 66)
+	mu.Unlock()
+
+	diverged := make(chan int, 10)
+	stop := cache.StartAudit(AuditConfig[int]{
+		Interval:   time.Millisecond,
+		Batch:      3,
+		Locker:     mu,
+		OnDiverged: func(k int) { diverged <- k },
+	})
+	got := map[int]bool{}
+	timeout := time.After(10 * time.Second)
+	for len(got) < 2 {
+		select {
+		case k := <-diverged:
+			got[k] = true
+		case <-timeout:
+			t.Fatalf("expected 2 and 5 to be found, got %v", got)
+		}
+	}
+	stop()
+	if !got[2] || !got[5] {
+		t.Fatalf("expected 2 and 5 to diverge, got %v", got)
+	}
+	if v, _ := cache.peek(6); v != 66 {
+		t.Fatalf("expected the dirty value to be kept, got %d", v)
+	}
+	checkInvariants(t, cache)
+}
+
+func TestPin(t *testing.T) {
+
+	loads := 0
+
+	cache := New[int, int](2, Callbacks[int, int]{
+		GetValue: func(k int) (int, error) { loads += 1; return k, nil },
+	})
+
+	cache.Get(0)
+	if !cache.Pin(0) {
+		t.Fatal("expected pin to succeed")
+	}
+	if cache.Pin(100) {
+		t.Fatal("expected pin of missing key to fail")
+	}
+
+	for i := 1; i < 100; i += 1 {
+		_, err := cache.Get(i)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	loads = 0
+	cache.Get(0)
+	if loads != 0 {
+		t.Fatal("pinned key was evicted")
+	}
+
+	cache.Get(1)
+	cache.Pin(1)
+	_, err := cache.Get(200)
+	if err != ErrPinned {
+		t.Fatalf("expected ErrPinned, got %v", err)
+	}
+
+	cache.Unpin(1)
+	_, err = cache.Get(200)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWarm(t *testing.T) {
+
+	cache := New[int, int](100, Callbacks[int, int]{
+		GetValue: func(k int) (int, error) {
+			if k%10 == 0 {
+				return 0, errors.New("GetValue failed")
+			}
+			return k, nil
+		},
+	})
+
+	keys := []int{}
+	for i := 0; i < 100; i += 1 {
+		keys = append(keys, i)
+	}
+
+	err := cache.Warm(keys, 8)
+	warmErr, ok := err.(*WarmError[int])
+	if !ok {
+		t.Fatalf("expected a WarmError, got %v", err)
+	}
+	if len(warmErr.Errors) != 10 {
+		t.Fatalf("bad failure count: got=%d want=10", len(warmErr.Errors))
+	}
+	if len(cache.data) != 90 {
+		t.Fatalf("bad resident count: got=%d want=90", len(cache.data))
+	}
+}
+
+func TestPrefetch(t *testing.T) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cache := New[int, int](100, Callbacks[int, int]{
+		GetValue: func(k int) (int, error) {
+			if k == 5 {
+				cancel()
+			}
+			return k, nil
+		},
+	})
+
+	keys := []int{}
+	for i := 0; i < 100; i += 1 {
+		keys = append(keys, i)
+	}
+
+	err := cache.Prefetch(ctx, keys, 1)
+	if err != context.Canceled {
+		t.Fatalf("expected cancellation, got %v", err)
+	}
+	if n := len(cache.data); n < 6 || n > 7 {
+		t.Fatalf("expected loading to stop soon after cancellation, got %d keys", n)
+	}
+	checkInvariants(t, cache)
+}
+
+func TestMaxConcurrentLoads(t *testing.T) {
+
+	var running, peak atomic.Int32
+	cache := New[int, int](100, Callbacks[int, int]{
+		GetValue: func(k int) (int, error) {
+			n := running.Add(1)
+			defer running.Add(-1)
+			for {
+				p := peak.Load()
+				if n <= p || peak.CompareAndSwap(p, n) {
+					break
+				}
+			}
+			time.Sleep(time.Millisecond)
+			return k, nil
+		},
+	}, WithMaxConcurrentLoads[int, int](2))
+
+	keys := []int{}
+	for i := 0; i < 40; i += 1 {
+		keys = append(keys, i)
+	}
+	err := cache.Warm(keys, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := peak.Load(); p < 1 || p > 2 {
+		t.Fatalf("expected at most 2 concurrent loads, got %d", p)
+	}
+	if len(cache.data) != 40 {
+		t.Fatalf("bad resident count: got=%d want=40", len(cache.data))
+	}
+
+	// Loads waiting for a slot under the lock must not block loads that
+	// need the lock to finish.
+	mu := &sync.Mutex{}
+	results := []<-chan Result[int]{}
+	for i := 40; i < 48; i += 1 {
+		results = append(results, cache.GetAsync(i, mu))
+		mu.Lock()
+		cache.Get(i + 100)
+		mu.Unlock()
+	}
+	for _, ch := range results {
+		if r := <-ch; r.Err != nil {
+			t.Fatal(r.Err)
+		}
+	}
+}
+
+type countingLimiter struct {
+	waits int
+	err   error
+}
+
+func (l *countingLimiter) Wait(ctx context.Context) error {
+	l.waits += 1
+	return l.err
+}
+
+func TestLoadLimiter(t *testing.T) {
+
+	limiter := &countingLimiter{}
+	loads := 0
+	cache := New[int, int](10, Callbacks[int, int]{
+		GetValue: func(k int) (int, error) {
+			loads += 1
+			return k, nil
+		},
+	}, WithLoadLimiter[int, int](limiter))
+
+	cache.Get(1)
+	cache.Get(1)
+	cache.Get(2)
+	if limiter.waits != 2 || loads != 2 {
+		t.Fatalf("expected a wait per load, got %d waits and %d loads", limiter.waits, loads)
+	}
+
+	limiter.err = errors.New("rate limited")
+	_, err := cache.Get(3)
+	if !errors.Is(err, limiter.err) || loads != 2 {
+		t.Fatalf("expected the limiter error without a load, got %v", err)
+	}
+	var loadErr *LoadError[int]
+	if !errors.As(err, &loadErr) || loadErr.Key != 3 {
+		t.Fatalf("expected a LoadError, got %v", err)
+	}
+}
+
+func TestLoadRetry(t *testing.T) {
+
+	failures := map[int]int{1: 2, 2: 5}
+	calls := 0
+	cache := New[int, int](10, Callbacks[int, int]{
+		GetValue: func(k int) (int, error) {
+			calls += 1
+			if k == 3 {
+				return 0, ErrNotFound
+			}
+			if failures[k] > 0 {
+				failures[k] -= 1
+				return 0, errors.New("transient")
+			}
+			return k, nil
+		},
+	}, WithLoadRetry[int, int](LoadRetryPolicy{
+		Attempts:   3,
+		Backoff:    time.Microsecond,
+		MaxBackoff: 2 * time.Microsecond,
+	}))
+
+	v, err := cache.Get(1)
+	if err != nil || v != 1 || calls != 3 {
+		t.Fatalf("expected success on the third attempt, got %v %v after %d calls", v, err, calls)
+	}
+	calls = 0
+	_, err = cache.Get(2)
+	if err == nil || calls != 3 {
+		t.Fatalf("expected failure after 3 attempts, got %v after %d calls", err, calls)
+	}
+	calls = 0
+	_, err = cache.Get(3)
+	if !errors.Is(err, ErrNotFound) || calls != 1 {
+		t.Fatalf("expected ErrNotFound without retries, got %v after %d calls", err, calls)
+	}
+
+	policy := LoadRetryPolicy{Backoff: time.Second, MaxBackoff: 3 * time.Second}
+	for n, want := range []time.Duration{time.Second, 2 * time.Second, 3 * time.Second, 3 * time.Second} {
+		if d := policy.backoff(n + 1); d != want {
+			t.Fatalf("bad backoff after %d failures: got %v want %v", n+1, d, want)
+		}
+	}
+}
+
+func TestLoadTimeout(t *testing.T) {
+
+	release := make(chan struct{})
+	defer close(release)
+	cache := New[int, int](10, Callbacks[int, int]{
+		GetValue: func(k int) (int, error) {
+			if k == 1 {
+				<-release
+			}
+			if k == 2 {
+				panic("boom")
+			}
+			return k, nil
+		},
+	}, WithLoadTimeout[int, int](10*time.Millisecond), WithPanicRecovery[int, int]())
+
+	_, err := cache.Get(1)
+	if !errors.Is(err, ErrLoadTimeout) {
+		t.Fatalf("expected a timeout, got %v", err)
+	}
+	if _, ok := cache.data[1]; ok {
+		t.Fatal("expected nothing to be cached")
+	}
+	v, err := cache.Get(3)
+	if err != nil || v != 3 {
+		t.Fatalf("expected a fast load to succeed, got %v %v", v, err)
+	}
+	_, err = cache.Get(2)
+	var panicErr *PanicError
+	if !errors.As(err, &panicErr) {
+		t.Fatalf("expected the panic to be recovered, got %v", err)
+	}
+}
+
+func TestStaleOnTimeout(t *testing.T) {
+
+	release := make(chan struct{})
+	defer close(release)
+	hang := atomic.Bool{}
+	version := atomic.Int64{}
+	clock := fakeclock.New(time.Unix(0, 0))
+	cache := New[int, int](10, Callbacks[int, int]{
+		GetValue: func(k int) (int, error) {
+			if hang.Load() {
+				<-release
+			}
+			return k*10 + int(version.Load()), nil
+		},
+	}, WithLoadTimeout[int, int](10*time.Millisecond), WithStaleOnTimeout[int, int](),
+		WithClock[int, int](clock), WithExpiry(TTL[int, int](time.Minute)))
+
+	expect := func(v, want int, err error) {
+		t.Helper()
+		if err != nil || v != want {
+			t.Fatalf("expected %d, got %v %v", want, v, err)
+		}
+	}
+	cache.Get(1)
+	cache.Get(2)
+	hang.Store(true)
+	v, err := cache.Refresh(1)
+	expect(v, 10, err)
+	v, err = cache.GetWithOptions(2, GetOptions{ForceRefresh: true})
+	expect(v, 20, err)
+	_, err = cache.Get(3)
+	if !errors.Is(err, ErrLoadTimeout) {
+		t.Fatalf("expected a timeout for a key that is not cached, got %v", err)
+	}
+
+	// An expired value is served while its reloads time out.
+	clock.Advance(time.Minute)
+	for i := 0; i < 2; i += 1 {
+		v, err = cache.Get(1)
+		expect(v, 10, err)
+	}
+	hang.Store(false)
+	version.Store(1)
+	v, err = cache.Get(1)
+	expect(v, 11, err)
+	v, err = cache.Get(1)
+	expect(v, 11, err)
+
+	// Invalidated values are not.
+	cache.BumpGeneration()
+	hang.Store(true)
+	_, err = cache.Get(1)
+	if !errors.Is(err, ErrLoadTimeout) {
+		t.Fatalf("expected an invalidated value not to be served, got %v", err)
+	}
+	checkInvariants(t, cache)
+}
+
+type mapL2 map[int]int
+
+func (m mapL2) Get(key int) (int, bool, error) {
+	v, ok := m[key]
+	return v, ok, nil
+}
+
+func (m mapL2) Set(key, value int) error {
+	m[key] = value
+	return nil
+}
+
+func (m mapL2) Delete(key int) error {
+	delete(m, key)
+	return nil
+}
+
+func TestTiered(t *testing.T) {
+
+	for _, policy := range []TierWritePolicy{TierWriteThrough, TierWriteBack} {
+		l2 := mapL2{}
+		origin := 0
+		cache, err := NewTiered(TieredConfig[int, int]{
+			Size: 2,
+			L2:   l2,
+			GetValue: func(k int) (int, error) {
+				origin += 1
+				return k, nil
+			},
+			WritePolicy: policy,
+			Demote:      true,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		cache.Set(1, 10)
+		if _, ok := l2[1]; ok != (policy == TierWriteThrough) {
+			t.Fatalf("policy %d: bad L2 after Set: %v", policy, l2)
+		}
+		cache.Get(2)
+		cache.Get(3)
+		// 1 was evicted, it is demoted or written back either way.
+		if l2[1] != 10 || l2[2] != 0 {
+			t.Fatalf("policy %d: expected only 1 in L2, got %v", policy, l2)
+		}
+		v, err := cache.Get(1)
+		if err != nil || v != 10 || origin != 2 {
+			t.Fatalf("policy %d: expected 1 from L2, got %v %v after %d origin loads", policy, v, err, origin)
+		}
+		err = cache.Delete(1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := l2[1]; ok {
+			t.Fatalf("policy %d: expected 1 to be deleted from L2", policy)
+		}
+
+		// Only evictions to make space are demoted.
+		before := fmt.Sprint(l2)
+		cache.L1().DeleteWhere(func(int, int) bool { return true })
+		if len(cache.L1().data) != 0 || fmt.Sprint(l2) != before {
+			t.Fatalf("policy %d: expected deleted values to stay out of L2, got %v, was %s", policy, l2, before)
+		}
+		checkInvariants(t, cache.L1())
+	}
+}
+
+type countingL2 struct {
+	mapL2
+	sets int
+}
+
+func (l *countingL2) Set(key, value int) error {
+	l.sets += 1
+	return l.mapL2.Set(key, value)
+}
+
+func TestTieredWriteBackDemote(t *testing.T) {
+
+	l2 := &countingL2{mapL2: mapL2{}}
+	cache, err := NewTiered(TieredConfig[int, int]{
+		Size:        1,
+		L2:          l2,
+		GetValue:    func(k int) (int, error) { return k, nil },
+		WritePolicy: TierWriteBack,
+		Demote:      true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache.Set(1, 10)
+	cache.Get(2)
+	// 1 is written back once, not also demoted.
+	if l2.sets != 1 || l2.mapL2[1] != 10 {
+		t.Fatalf("expected one write of 1, got %d writes and %v", l2.sets, l2.mapL2)
+	}
+	cache.Get(3)
+	if l2.sets != 2 || l2.mapL2[2] != 2 {
+		t.Fatalf("expected a clean value to be demoted, got %d writes and %v", l2.sets, l2.mapL2)
+	}
+}
+
+func TestDiskL2(t *testing.T) {
+
+	l2, err := NewDiskL2[int, int](t.TempDir(), GobCodec[int]{}, GobCodec[int]{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	loads := 0
+	cache, err := NewTiered(TieredConfig[int, int]{
+		Size: 2,
+		L2:   l2,
+		GetValue: func(k int) (int, error) {
+			loads += 1
+			return k * 10, nil
+		},
+		Demote: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i += 1 {
+		cache.Get(i)
+	}
+	for i := 0; i < 5; i += 1 {
+		v, err := cache.Get(i)
+		if err != nil || v != i*10 {
+			t.Fatalf("bad value for %d: %v %v", i, v, err)
+		}
+	}
+	if loads != 5 {
+		t.Fatalf("expected evicted values to come from disk, got %d loads", loads)
+	}
+	err = cache.Delete(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, err := l2.Get(0); ok || err != nil {
+		t.Fatalf("expected 0 to be deleted from disk, got %v %v", ok, err)
+	}
+	if err := l2.Delete(0); err != nil {
+		t.Fatalf("expected deleting a missing key to succeed, got %v", err)
+	}
+}
+
+func TestDiskL2DistinctKeys(t *testing.T) {
+
+	type pair struct{ A, B string }
+	l2, err := NewDiskL2[pair, int](t.TempDir(), JSONCodec[pair]{}, JSONCodec[int]{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Both print as {a b }.
+	err = l2.Set(pair{"a b", ""}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, ok, err := l2.Get(pair{"a", "b "}); ok || err != nil {
+		t.Fatalf("expected a miss, got %v %v %v", v, ok, err)
+	}
+	if v, ok, err := l2.Get(pair{"a b", ""}); !ok || err != nil || v != 1 {
+		t.Fatalf("expected a hit, got %v %v %v", v, ok, err)
+	}
+
+	// A file holding another key, as after a hash collision, is a miss.
+	other, _ := JSONCodec[pair]{}.Encode(pair{"a", "b "})
+	mine, _ := JSONCodec[pair]{}.Encode(pair{"a b", ""})
+	err = os.Rename(l2.path(mine), l2.path(other))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, ok, err := l2.Get(pair{"a", "b "}); ok || err != nil {
+		t.Fatalf("expected a mismatched key to miss, got %v %v %v", v, ok, err)
+	}
+}
+
+func TestCodecs(t *testing.T) {
+
+	type record struct {
+		Name string
+		Tags []string
+	}
+	value := record{Name: "a", Tags: []string{"x", "y"}}
+	for _, codec := range []Codec[record]{GobCodec[record]{}, JSONCodec[record]{}} {
+		data, err := codec.Encode(value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoded, err := codec.Decode(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if decoded.Name != value.Name || strings.Join(decoded.Tags, ",") != "x,y" {
+			t.Fatalf("%T: bad round trip: %+v", codec, decoded)
+		}
+		if _, err := codec.Decode([]byte("{")); err == nil {
+			t.Fatalf("%T: expected bad data to fail", codec)
+		}
+	}
+}
+
+func TestCompressed(t *testing.T) {
+
+	codec := Compressed[string](JSONCodec[string]{}, FlateCompressor{})
+	for _, value := range []string{strings.Repeat("abc", 1000), "x"} {
+		data, err := codec.Encode(value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(value) > 100 && (data[0] != compressedValue || len(data) > 100) {
+			t.Fatalf("expected a repetitive value to be compressed, got %d bytes", len(data))
+		}
+		if len(value) == 1 && data[0] != uncompressedValue {
+			t.Fatal("expected a tiny value to be stored uncompressed")
+		}
+		decoded, err := codec.Decode(data)
+		if err != nil || decoded != value {
+			t.Fatalf("bad round trip: %v", err)
+		}
+	}
+	if _, err := codec.Decode([]byte{9}); err == nil {
+		t.Fatal("expected an unknown header to fail")
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+
+	cache := NewManual[int, string](10)
+	for i := 0; i < 6; i += 1 {
+		cache.Set(i, fmt.Sprint("v", i))
+	}
+	cache.Get(1)
+	cache.Get(3)
+	var buf bytes.Buffer
+	err := cache.Snapshot(&buf, GobCodec[int]{}, JSONCodec[string]{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	snapshot := buf.Bytes()
+
+	restored := NewManual[int, string](10)
+	err = restored.Restore(bytes.NewReader(snapshot), GobCodec[int]{}, JSONCodec[string]{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, got := cache.Cache().Inspect(), restored.Cache().Inspect()
+	if fmt.Sprint(want.T1, want.T2) != fmt.Sprint(got.T1, got.T2) || want.Partition != got.Partition {
+		t.Fatalf("bad restored state:\n got=%+v\nwant=%+v", got, want)
+	}
+	if v, ok := restored.Get(3); !ok || v != "v3" {
+		t.Fatalf("bad restored value: %q", v)
+	}
+	checkInvariants(t, restored.Cache())
+
+	err = restored.Restore(bytes.NewReader(snapshot), GobCodec[int]{}, JSONCodec[string]{})
+	if err == nil {
+		t.Fatal("expected restoring into a non-empty cache to fail")
+	}
+
+	// A smaller cache keeps the most recent entries, frequent ones first.
+	small := NewManual[int, string](3)
+	err = small.Restore(bytes.NewReader(snapshot), GobCodec[int]{}, JSONCodec[string]{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	state := small.Cache().Inspect()
+	if fmt.Sprint(state.T1, state.T2) != "[5] [3 1]" {
+		t.Fatalf("bad truncated state: %+v", state)
+	}
+	checkInvariants(t, small.Cache())
+
+	newer := append([]byte{}, snapshot...)
+	newer[4] = byte(SnapshotVersion() + 1)
+	err = NewManual[int, string](10).Restore(bytes.NewReader(newer), GobCodec[int]{}, JSONCodec[string]{})
+	var versionErr *SnapshotVersionError
+	if !errors.As(err, &versionErr) || versionErr.Version != SnapshotVersion()+1 {
+		t.Fatalf("expected a version error, got %v", err)
+	}
+	for _, bad := range [][]byte{[]byte("nope"), snapshot[:len(snapshot)-3]} {
+		empty := NewManual[int, string](10)
+		if empty.Restore(bytes.NewReader(bad), GobCodec[int]{}, JSONCodec[string]{}) == nil {
+			t.Fatalf("expected %q to be rejected", bad)
+		}
+		if empty.Len() != 0 {
+			t.Fatal("expected a failed restore to leave the cache empty")
+		}
+	}
+}
+
+func TestSnapshotIntegrity(t *testing.T) {
+
+	cache := NewManual[int, string](10)
+	for i := 0; i < 6; i += 1 {
+		cache.Set(i, fmt.Sprint("v", i))
+	}
+	var plain bytes.Buffer
+	err := cache.Snapshot(&plain, GobCodec[int]{}, JSONCodec[string]{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Flipping any byte is detected.
+	for i := 0; i < plain.Len(); i += 1 {
+		bad := append([]byte{}, plain.Bytes()...)
+		bad[i] ^= 0x10
+		empty := NewManual[int, string](10)
+		err := empty.Restore(bytes.NewReader(bad), GobCodec[int]{}, JSONCodec[string]{})
+		if err == nil {
+			t.Fatalf("expected a flipped byte at %d to be rejected", i)
+		}
+		if empty.Len() != 0 {
+			t.Fatal("expected a failed restore to leave the cache empty")
+		}
+	}
+
+	block, err := aes.NewCipher(make([]byte, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sealed bytes.Buffer
+	err = cache.Snapshot(&sealed, GobCodec[int]{}, JSONCodec[string]{}, WithSnapshotAEAD(aead))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(sealed.Bytes(), []byte("v3")) {
+		t.Fatal("expected values to be encrypted")
+	}
+	restored := NewManual[int, string](10)
+	err = restored.Restore(bytes.NewReader(sealed.Bytes()), GobCodec[int]{}, JSONCodec[string]{}, WithSnapshotAEAD(aead))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := restored.Get(3); !ok || v != "v3" {
+		t.Fatalf("bad restored value: %q", v)
+	}
+
+	if NewManual[int, string](10).Restore(bytes.NewReader(sealed.Bytes()), GobCodec[int]{}, JSONCodec[string]{}) == nil {
+		t.Fatal("expected an encrypted snapshot to need the AEAD")
+	}
+	block, _ = aes.NewCipher(bytes.Repeat([]byte{1}, 32))
+	wrongKey, _ := cipher.NewGCM(block)
+	err = NewManual[int, string](10).Restore(bytes.NewReader(sealed.Bytes()), GobCodec[int]{}, JSONCodec[string]{}, WithSnapshotAEAD(wrongKey))
+	if !errors.Is(err, ErrSnapshotCorrupt) {
+		t.Fatalf("expected the wrong key to be rejected, got %v", err)
+	}
+}
+
+func TestSnapshotPartition(t *testing.T) {
+
+	forge := func(part, size uint64, keys ...int) []byte {
+		var buf bytes.Buffer
+		w := bufio.NewWriter(&buf)
+		w.Write(snapshotMagic[:])
+		writeUvarint(w, 1)
+		w.WriteByte(0)
+		frames := &snapshotFrames{}
+		frames.write(w, binary.AppendUvarint(binary.AppendUvarint(nil, part), size))
+		for _, k := range keys {
+			key := []byte(fmt.Sprint(k))
+			record := []byte{snapshotT1}
+			record = binary.AppendUvarint(record, uint64(len(key)))
+			record = append(record, key...)
+			record = binary.AppendUvarint(record, 1)
+			record = append(record, '0')
+			frames.write(w, record)
+		}
+		frames.write(w, []byte{snapshotEnd})
+		w.Flush()
+		return buf.Bytes()
+	}
+	restore := func(size int, snapshot []byte) (*Manual[int, int], error) {
+		cache := NewManual[int, int](size)
+		err := cache.Restore(bytes.NewReader(snapshot), JSONCodec[int]{}, JSONCodec[int]{})
+		return cache, err
+	}
+
+	// Checksums are valid, but no cache could have written these.
+	for _, bad := range [][]byte{forge(11, 10), forge(1<<63, 10), forge(0, 2, 1, 2, 3)} {
+		cache, err := restore(10, bad)
+		if !errors.Is(err, ErrSnapshotCorrupt) {
+			t.Fatalf("expected %q to be corrupt, got %v", bad, err)
+		}
+		if cache.Len() != 0 {
+			t.Fatal("expected a failed restore to leave the cache empty")
+		}
+	}
+
+	// A partition from a larger cache is clamped.
+	cache, err := restore(3, forge(7, 10, 1, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := cache.Cache().Inspect().Partition; p != 3 {
+		t.Fatalf("expected the partition to be clamped to 3, got %d", p)
+	}
+	checkInvariants(t, cache.Cache())
+}
+
+func TestTrim(t *testing.T) {
+
+	cache := New[int, int](10, Callbacks[int, int]{
+		GetValue: func(k int) (int, error) { return k, nil },
+	})
+
+	_, _, ok, err := cache.EvictOldest()
+	if ok || err != nil {
+		t.Fatal("expected nothing to evict")
+	}
+
+	for i := 0; i < 10; i += 1 {
+		cache.Get(i)
+	}
+
+	k, v, ok, err := cache.EvictOldest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok || k != 0 || v != 0 {
+		t.Fatalf("bad eviction: %v %v %v", k, v, ok)
+	}
+
+	err = cache.Trim(4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cache.data) != 5 {
+		t.Fatalf("bad resident count: got=%d want=5", len(cache.data))
+	}
+	if cache.b1.Len() != 5 {
+		t.Fatalf("bad ghost count: got=%d want=5", cache.b1.Len())
+	}
+
+	err = cache.Trim(100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cache.data) != 0 {
+		t.Fatalf("bad resident count: got=%d want=0", len(cache.data))
+	}
+
+	// Refilling must not need to evict anything.
+	for i := 100; i < 120; i += 1 {
+		_, err := cache.Get(i)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(cache.data) != 10 {
+		t.Fatalf("bad resident count: got=%d want=10", len(cache.data))
+	}
+}
+
+func TestHitRatioAlarm(t *testing.T) {
+
+	fired := []Stats{}
+
+	cache := New(10, Callbacks[int, int]{
+		GetValue: func(k int) (int, error) { return k, nil },
+	}, WithHitRatioAlarm[int, int](HitRatioAlarm{
+		MinRatio:   0.5,
+		Window:     10,
+		Windows:    2,
+		OnDegraded: func(s Stats) { fired = append(fired, s) },
+	}))
+
+	// A hot working set keeps the ratio healthy.
+	for i := 0; i < 100; i += 1 {
+		cache.Get(i % 5)
+	}
+	if len(fired) != 0 {
+		t.Fatal("unexpected alarm")
+	}
+
+	// A scan misses every time.
+	for i := 100; i < 120; i += 1 {
+		cache.Get(i)
+	}
+	if len(fired) != 1 {
+		t.Fatalf("expected one alarm, got %d", len(fired))
+	}
+	if fired[0].Hits != 0 || fired[0].Misses != 10 {
+		t.Fatalf("bad window stats: %+v", fired[0])
+	}
+
+	stats := cache.Stats()
+	if stats.Hits != 95 || stats.Misses != 25 {
+		t.Fatalf("bad stats: %+v", stats)
+	}
+}
+
+func TestWriteBack(t *testing.T) {
+
+	origin := map[int]int{}
+
+	cache := New[int, int](2, Callbacks[int, int]{
+		GetValue: func(k int) (int, error) { return origin[k], nil },
+		WriteValue: func(k, v int) error {
+			origin[k] = v
+			return nil
+		},
+	})
+
+	cache.Set(1, 10)
+	cache.Set(2, 20)
+	if len(origin) != 0 {
+		t.Fatal("write-back happened too early")
+	}
+
+	// Evicting 1 writes it back.
+	cache.Get(3)
+	if origin[1] != 10 || len(origin) != 1 {
+		t.Fatalf("bad origin after eviction: %v", origin)
+	}
+
+	err := cache.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if origin[2] != 20 {
+		t.Fatalf("bad origin after flush: %v", origin)
+	}
+	if cache.dirtyLen != 0 {
+		t.Fatal("expected no dirty entries after flush")
+	}
+}
+
+func TestWriteThrough(t *testing.T) {
+
+	origin := map[int]int{}
+	fail := false
+
+	cache := New[int, int](2, Callbacks[int, int]{
+		GetValue: func(k int) (int, error) { return origin[k], nil },
+		SetValue: func(k, v int) error {
+			if fail {
+				return errors.New("SetValue failed")
+			}
+			origin[k] = v
+			return nil
+		},
+	})
+
+	err := cache.Set(1, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if origin[1] != 10 {
+		t.Fatal("expected value to be written through")
+	}
+
+	fail = true
+	err = cache.Set(1, 11)
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	v, _ := cache.Get(1)
+	if v != 10 {
+		t.Fatalf("failed Set changed the cache: got=%d want=10", v)
+	}
+}
+
+func TestNewWithConfig(t *testing.T) {
+
+	getValue := func(k int) (int, error) { return k, nil }
+
+	for _, cfg := range []Config[int, int]{
+		{Size: 0, Callbacks: Callbacks[int, int]{GetValue: getValue}},
+		{Size: -1, Callbacks: Callbacks[int, int]{GetValue: getValue}},
+		{Size: 10},
+		{
+			Size:      10,
+			Callbacks: Callbacks[int, int]{GetValue: getValue},
+			Options: []Option[int, int]{
+				WithHitRatioAlarm[int, int](HitRatioAlarm{OnDegraded: func(Stats) {}}),
+			},
+		},
+		{
+			Size:      10,
+			Callbacks: Callbacks[int, int]{GetValue: getValue},
+			Options:   []Option[int, int]{WithMaxConcurrentLoads[int, int](-1)},
+		},
+		{
+			Size:      10,
+			Callbacks: Callbacks[int, int]{GetValue: getValue},
+			Options:   []Option[int, int]{WithLoadRetry[int, int](LoadRetryPolicy{})},
+		},
+		{
+			Size:      10,
+			Callbacks: Callbacks[int, int]{GetValue: getValue},
+			Options:   []Option[int, int]{WithVictimCache[int, int](-1)},
+		},
+		{
+			Size:      10,
+			Callbacks: Callbacks[int, int]{GetValue: getValue},
+			Options:   []Option[int, int]{WithEvictionChannel[int, int](-1)},
+		},
+	} {
+		_, err := NewWithConfig(cfg)
+		if err == nil {
+			t.Fatalf("expected an error for %+v", cfg)
+		}
+	}
+
+	cache, err := NewWithConfig(Config[int, int]{
+		Size:      10,
+		Callbacks: Callbacks[int, int]{GetValue: getValue},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := cache.Get(1)
+	if err != nil || v != 1 {
+		t.Fatal("bad value")
+	}
+}
+
+func TestManual(t *testing.T) {
+
+	cache := NewManual[int, int](2)
+
+	_, ok := cache.Get(1)
+	if ok {
+		t.Fatal("unexpected hit")
+	}
+
+	cache.Set(1, 10)
+	cache.Set(2, 20)
+	cache.Set(3, 30)
+
+	_, ok = cache.Get(1)
+	if ok {
+		t.Fatal("expected 1 to be evicted")
+	}
+	v, ok := cache.Get(3)
+	if !ok || v != 30 {
+		t.Fatal("bad value")
+	}
+
+	if !cache.Contains(2) || cache.Contains(1) || cache.Len() != 2 {
+		t.Fatalf("bad contents: %v %v %d", cache.Contains(2), cache.Contains(1), cache.Len())
+	}
+	before := cache.Cache().Inspect()
+	if v, ok := cache.Peek(2); !ok || v != 20 {
+		t.Fatalf("bad peek: %v %v", v, ok)
+	}
+	if fmt.Sprint(cache.Cache().Inspect()) != fmt.Sprint(before) {
+		t.Fatal("peek counted as an access")
+	}
+	if ok, err := cache.Delete(2); !ok || err != nil {
+		t.Fatalf("bad delete: %v %v", ok, err)
+	}
+	if cache.Contains(2) || cache.Len() != 1 {
+		t.Fatal("expected 2 to be deleted")
+	}
+}
+
+func TestManualSetError(t *testing.T) {
+
+	errInjected := errors.New("injected")
+	cache := NewManual(1, WithFaults[int, int](Faults[int]{
+		EvictError: func(k int) error { return errInjected },
+	}))
+	err := cache.Set(1, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = cache.Set(2, 20)
+	if err != errInjected {
+		t.Fatalf("expected the eviction error, got %v", err)
+	}
+	if cache.Contains(2) {
+		t.Fatal("failed set was cached")
+	}
+}
+
+func TestSetMany(t *testing.T) {
+
+	cache := NewManual[int, int](2)
+	n, err := cache.SetMany([]KV[int, int]{{1, 10}, {2, 20}})
+	if err != nil || n != 2 {
+		t.Fatalf("expected 2 entries set, got %d, %v", n, err)
+	}
+	cache.Pin(1)
+	cache.Pin(2)
+	// The update of 1 fits, 3 needs space but every entry is pinned, so 4
+	// is never tried.
+	n, err = cache.SetMany([]KV[int, int]{{1, 11}, {3, 30}, {4, 40}})
+	if err != ErrPinned || n != 1 {
+		t.Fatalf("expected 1 entry set and ErrPinned, got %d, %v", n, err)
+	}
+	if v, _ := cache.Peek(1); v != 11 {
+		t.Fatalf("expected the entry before the failure to stay set, got %d", v)
+	}
+	if cache.Contains(3) || cache.Contains(4) {
+		t.Fatal("entries from the failure on were set")
+	}
+	checkInvariants(t, cache.Cache())
+}
+
+func TestGetWithLoader(t *testing.T) {
+
+	cache := New[int, int](10, Callbacks[int, int]{
+		GetValue: func(k int) (int, error) { return k, nil },
+	})
+
+	v, err := cache.GetWithLoader(1, func(k int) (int, error) { return k * 100, nil })
+	if err != nil || v != 100 {
+		t.Fatal("bad value")
+	}
+
+	// Cached values are returned without calling the loader.
+	v, err = cache.GetWithLoader(1, func(k int) (int, error) { panic("unexpected load") })
+	if err != nil || v != 100 {
+		t.Fatal("bad value")
+	}
+
+	v, err = cache.GetOrCompute(2, func() (int, error) { return 42, nil })
+	if err != nil || v != 42 {
+		t.Fatal("bad value")
+	}
+	v, err = cache.GetOrCompute(2, func() (int, error) { panic("unexpected compute") })
+	if err != nil || v != 42 {
+		t.Fatal("bad value")
+	}
+}
+
+func TestUpdate(t *testing.T) {
+
+	cache := NewManual[string, int](10)
+
+	incr := func(old int, exists bool) (int, error) {
+		if !exists {
+			return 1, nil
+		}
+		return old + 1, nil
+	}
+
+	for i := 0; i < 3; i += 1 {
+		err := cache.Update("x", incr)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	v, _ := cache.Get("x")
+	if v != 3 {
+		t.Fatalf("bad value: got=%d want=3", v)
+	}
+
+	err := cache.Update("x", func(int, bool) (int, error) { return 0, errors.New("update failed") })
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	v, _ = cache.Get("x")
+	if v != 3 {
+		t.Fatal("failed update changed the cache")
+	}
+}
+
+func TestUpdateLoads(t *testing.T) {
+
+	loads := 0
+	errBackend := errors.New("backend down")
+	cache := New[string, int](10, Callbacks[string, int]{
+		GetValue: func(k string) (int, error) {
+			loads += 1
+			switch k {
+			case "missing":
+				return 0, ErrNotFound
+			case "broken":
+				return 0, errBackend
+			}
+			return 10, nil
+		},
+	})
+	incr := func(old int, exists bool) (int, error) {
+		if !exists {
+			return -1, nil
+		}
+		return old + 1, nil
+	}
+
+	// A miss is loaded and the loaded value passed to fn.
+	err := cache.Update("x", incr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = cache.Update("x", incr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := cache.Get("x"); v != 12 || loads != 1 {
+		t.Fatalf("expected one load and 12, got %d loads and %d", loads, v)
+	}
+
+	err = cache.Update("missing", incr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := cache.Get("missing"); v != -1 {
+		t.Fatalf("expected fn to see a missing key, got %d", v)
+	}
+
+	err = cache.Update("broken", func(int, bool) (int, error) {
+		t.Fatal("fn called after a failed load")
+		return 0, nil
+	})
+	if !errors.Is(err, errBackend) {
+		t.Fatalf("expected the load error, got %v", err)
+	}
+	if _, ok := cache.data["broken"]; ok {
+		t.Fatal("failed update changed the cache")
+	}
+	checkInvariants(t, cache)
+}
+
+func TestVictimCache(t *testing.T) {
+
+	loads := 0
+
+	cache := New(2, Callbacks[int, int]{
+		GetValue: func(k int) (int, error) { loads += 1; return k, nil },
+	}, WithVictimCache[int, int](1))
+
+	cache.Get(1)
+	cache.Get(2)
+	cache.Get(3)
+	loads = 0
+
+	// 1 was just evicted and is recovered without a load.
+	v, err := cache.Get(1)
+	if err != nil || v != 1 {
+		t.Fatal("bad value")
+	}
+	if loads != 0 {
+		t.Fatal("expected victim cache hit")
+	}
+
+	// Only the single most recent victim is kept.
+	cache.Get(4)
+	cache.Get(5)
+	cache.Get(2)
+	if loads != 3 {
+		t.Fatalf("bad load count: got=%d want=3", loads)
+	}
+}
+
+func TestVictimCacheStale(t *testing.T) {
+
+	origin := map[int]int{}
+	clock := fakeclock.New(time.Unix(0, 0))
+	cache := New(1, Callbacks[int, int]{
+		GetValue: func(k int) (int, error) { return origin[k], nil },
+	}, WithVictimCache[int, int](2), WithClock[int, int](clock),
+		WithExpiry(TTL[int, int](time.Minute)))
+
+	expect := func(k, want int) {
+		t.Helper()
+		v, err := cache.Get(k)
+		if err != nil || v != want {
+			t.Fatalf("expected %d for %d, got %v %v", want, k, v, err)
+		}
+	}
+	evictTo := func(k int) {
+		t.Helper()
+		cache.Get(k)
+		if _, ok := cache.data[k]; !ok {
+			t.Fatalf("expected %d to be resident", k)
+		}
+	}
+
+	cache.Get(1)
+	evictTo(2)
+	origin[1] = 100
+	cache.BumpGeneration()
+	expect(1, 100)
+
+	evictTo(2)
+	origin[1] = 200
+	cache.Delete(1)
+	expect(1, 200)
+
+	evictTo(2)
+	origin[1] = 300
+	cache.DeleteWhere(func(k, _ int) bool { return k == 1 })
+	expect(1, 300)
+
+	// A recovered victim keeps its expiry.
+	clock.Advance(30 * time.Second)
+	evictTo(2)
+	expect(1, 300)
+	origin[1] = 400
+	clock.Advance(30 * time.Second)
+	evictTo(2)
+	expect(1, 400)
+
+	// So does one that expires while evicted.
+	origin[1] = 500
+	evictTo(2)
+	clock.Advance(time.Minute)
+	expect(1, 500)
+	checkInvariants(t, cache)
+}
+
+func TestFaults(t *testing.T) {
+
+	errInjected := errors.New("injected")
+	failKey := 0
+
+	cache := New(1, Callbacks[int, int]{
+		GetValue: func(k int) (int, error) { return k, nil },
+	}, WithFaults[int, int](Faults[int]{
+		LoadError: func(k int) error {
+			if k == failKey {
+				return errInjected
+			}
+			return nil
+		},
+		EvictError: func(k int) error {
+			if k == 2 {
+				return errInjected
+			}
+			return nil
+		},
+	}))
+
+	_, err := cache.Get(0)
+	if !errors.Is(err, errInjected) {
+		t.Fatalf("expected injected load error, got %v", err)
+	}
+
+	failKey = -1
+	cache.Get(1)
+	cache.Get(2)
+	_, err = cache.Get(3)
+	if err != errInjected {
+		t.Fatalf("expected injected evict error, got %v", err)
+	}
+}
+
+func TestLoadError(t *testing.T) {
+
+	cache := New(1, Callbacks[string, int]{
+		GetValue: func(k string) (int, error) { return 0, ErrNotFound },
+	})
+	_, err := cache.Get("missing")
+	var loadErr *LoadError[string]
+	if !errors.As(err, &loadErr) || loadErr.Key != "missing" {
+		t.Fatalf("expected a LoadError for the key, got %v", err)
+	}
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestSkipCache(t *testing.T) {
+
+	cache := New(2, Callbacks[int, int]{
+		GetValue: func(k int) (int, error) {
+			if k > 100 {
+				return k, fmt.Errorf("too large: %w", ErrSkipCache)
+			}
+			return k, nil
+		},
+	})
+	v, err := cache.Get(1000)
+	if err != nil || v != 1000 {
+		t.Fatalf("expected uncached value, got %v %v", v, err)
+	}
+	if cache.T1Len() != 0 {
+		t.Fatal("expected value not to be cached")
+	}
+	err = cache.Warm([]int{1, 1000}, 1)
+	if err != nil || cache.T1Len() != 1 {
+		t.Fatalf("expected only 1 to be warmed, got %v %d", err, cache.T1Len())
+	}
+}
+
+func TestCorruption(t *testing.T) {
+
+	var reported *CorruptionError[int]
+	cache := NewManual[int, int](4,
+		WithOpHistory[int, int](2),
+		WithCorruptionHandler[int, int](func(err *CorruptionError[int]) { reported = err }),
+	)
+	for i := 0; i < 3; i += 1 {
+		cache.Set(i, i)
+	}
+	if err := cache.Cache().CheckConsistency(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Simulate corruption by removing a key behind the cache's back.
+	cache.Cache().t1.Remove(cache.Cache().data[2])
+	if err := cache.Cache().CheckConsistency(); err == nil {
+		t.Fatal("expected corruption to be detected")
+	}
+	_, err := cache.Delete(2)
+	if err == nil || reported == nil || err != error(reported) {
+		t.Fatalf("expected corruption to be reported, got %v", err)
+	}
+	if len(reported.Ops) != 2 || reported.Ops[0] != (Op[int]{Name: "set", Key: 1}) || reported.Ops[1] != (Op[int]{Name: "set", Key: 2}) {
+		t.Fatalf("bad op history: %v", reported.Ops)
+	}
+}
+
+func TestGetOptions(t *testing.T) {
+
+	loads := 0
+	cache := New(2, Callbacks[int, int]{
+		GetValue: func(k int) (int, error) {
+			loads += 1
+			return k * loads, nil
+		},
+	})
+
+	v, err := cache.GetWithOptions(1, GetOptions{SkipCache: true})
+	if err != nil || v != 1 || cache.T1Len() != 0 {
+		t.Fatalf("expected an uncached load, got %v %v", v, err)
+	}
+
+	cache.Get(1)
+	v, err = cache.GetWithOptions(1, GetOptions{NoPromote: true})
+	if err != nil || v != 2 || cache.T1Len() != 1 {
+		t.Fatalf("expected an unpromoted hit, got %v %v", v, err)
+	}
+
+	v, err = cache.GetWithOptions(1, GetOptions{ForceRefresh: true, NoPromote: true})
+	if err != nil || v != 3 || cache.T1Len() != 1 {
+		t.Fatalf("expected an unpromoted refresh, got %v %v", v, err)
+	}
+	v, err = cache.GetWithOptions(1, GetOptions{ForceRefresh: true})
+	if err != nil || v != 4 || cache.T2Len() != 1 {
+		t.Fatalf("expected a promoted refresh, got %v %v", v, err)
+	}
+	if v, _ := cache.Get(1); v != 4 {
+		t.Fatalf("expected refreshed value to be cached, got %v", v)
+	}
+}
+
+func TestGetOptionsWriteBack(t *testing.T) {
+
+	clock := fakeclock.New(time.Unix(0, 0))
+	written := map[int]int{}
+	cache := New(2, Callbacks[int, int]{
+		GetValue: func(k int) (int, error) { return 100, nil },
+		WriteValue: func(k, v int) error {
+			written[k] = v
+			return nil
+		},
+	}, WithClock[int, int](clock), WithExpiry(TTI[int, int](time.Minute)), WithEntryStats[int, int]())
+
+	cache.Set(1, 5)
+	v, err := cache.GetWithOptions(1, GetOptions{ForceRefresh: true})
+	if err != nil || v != 5 {
+		t.Fatalf("expected the dirty value to be kept, got %v %v", v, err)
+	}
+	cache.Flush()
+	if written[1] != 5 {
+		t.Fatalf("the set value was not written back: %v", written)
+	}
+
+	// A hit without promotion leaves the idle expiry alone.
+	clock.Advance(40 * time.Second)
+	cache.GetWithOptions(1, GetOptions{NoPromote: true})
+	clock.Advance(40 * time.Second)
+	if _, ok := cache.EntryInfo(1); ok {
+		t.Fatal("expected an unpromoted hit not to extend the idle expiry")
+	}
+}
+
+func TestDependencies(t *testing.T) {
+
+	cache := NewManual[string, int](4)
+	for _, k := range []string{"parent", "child", "grandchild", "other"} {
+		cache.Set(k, 0)
+	}
+	if cache.AddDependency("missing", "parent") {
+		t.Fatal("expected dependency of a missing key to fail")
+	}
+	cache.AddDependency("child", "parent")
+	cache.AddDependency("grandchild", "child")
+	cache.AddDependency("parent", "grandchild")
+
+	_, err := cache.Delete("parent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cache.Len() != 1 {
+		t.Fatalf("expected only other to remain, got %d entries", cache.Len())
+	}
+	if len(cache.Cache().dependents) != 0 || len(cache.Cache().dependencies) != 0 {
+		t.Fatalf("dependencies not forgotten: %v %v", cache.Cache().dependents, cache.Cache().dependencies)
+	}
+}
+
+func TestRefresh(t *testing.T) {
+
+	version := 0
+	cache := New(4, Callbacks[int, int]{
+		GetValue: func(k int) (int, error) {
+			if version < 0 {
+				return 0, errors.New("load failed")
+			}
+			return version, nil
+		},
+	})
+	cache.Get(1)
+	cache.Get(1)
+	cache.Get(2)
+	before := cache.Inspect()
+
+	version = 1
+	v, err := cache.Refresh(2)
+	if err != nil || v != 1 {
+		t.Fatalf("bad refresh: %v %v", v, err)
+	}
+	after := cache.Inspect()
+	if fmt.Sprint(before) != fmt.Sprint(after) {
+		t.Fatalf("refresh moved the entry: %v %v", before, after)
+	}
+
+	version = -1
+	_, err = cache.Refresh(2)
+	if err == nil {
+		t.Fatal("expected refresh to fail")
+	}
+	if v, _ := cache.Get(2); v != 1 {
+		t.Fatalf("failed refresh replaced the value, got %v", v)
+	}
+}
+
+func TestRefreshWriteBack(t *testing.T) {
+
+	origin := map[int]int{1: 100}
+	cache := New(2, Callbacks[int, int]{
+		GetValue: func(k int) (int, error) { return origin[k], nil },
+		WriteValue: func(k, v int) error {
+			origin[k] = v
+			return nil
+		},
+	})
+
+	cache.Set(1, 5)
+	v, err := cache.Refresh(1)
+	if err != nil || v != 5 {
+		t.Fatalf("expected refresh to keep the dirty value, got %v %v", v, err)
+	}
+	err = cache.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if origin[1] != 5 {
+		t.Fatalf("refresh lost the set value: %v", origin)
+	}
+
+	// Once written back the key refreshes normally.
+	origin[1] = 7
+	v, err = cache.Refresh(1)
+	if err != nil || v != 7 {
+		t.Fatalf("bad refresh after flush: %v %v", v, err)
+	}
+	cache.Flush()
+	if origin[1] != 7 {
+		t.Fatalf("refreshed value was written back: %v", origin)
+	}
+}
+
+func TestGetAsync(t *testing.T) {
+
+	release := make(chan struct{})
+	cache := New(4, Callbacks[int, int]{
+		GetValue: func(k int) (int, error) {
+			<-release
+			if k < 0 {
+				return 0, errors.New("negative")
+			}
+			return k * 10, nil
+		},
+	})
+	mu := &sync.Mutex{}
+	pending := cache.GetAsync(1, mu)
+	failed := cache.GetAsync(-1, mu)
+
+	// The lock is free while the loads run.
+	mu.Lock()
+	cache.Set(2, 2)
+	mu.Unlock()
+	close(release)
+
+	r := <-pending
+	if r.Err != nil || r.Value != 10 {
+		t.Fatalf("bad result: %v", r)
+	}
+	r = <-failed
+	var loadErr *LoadError[int]
+	if !errors.As(r.Err, &loadErr) || loadErr.Key != -1 {
+		t.Fatalf("expected a load error, got %v", r.Err)
+	}
+	r = <-cache.GetAsync(2, mu)
+	if r.Err != nil || r.Value != 2 {
+		t.Fatalf("expected a hit, got %v", r)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if _, ok := cache.data[1]; !ok {
+		t.Fatal("expected the loaded value to be cached")
+	}
+	checkInvariants(t, cache)
+}
+
+func TestGetAsyncSetWhileLoading(t *testing.T) {
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	written := map[int]int{}
+	cache := New(4, Callbacks[int, int]{
+		GetValue: func(k int) (int, error) {
+			close(started)
+			<-release
+			return 100, nil
+		},
+		WriteValue: func(k, v int) error {
+			written[k] = v
+			return nil
+		},
+	})
+	mu := &sync.Mutex{}
+	pending := cache.GetAsync(1, mu)
+
+	<-started
+	mu.Lock()
+	cache.Set(1, 5)
+	mu.Unlock()
+	close(release)
+
+	r := <-pending
+	if r.Err != nil || r.Value != 5 {
+		t.Fatalf("expected the set value, got %v", r)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	err := cache.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if written[1] != 5 {
+		t.Fatalf("load overwrote the set value: %v", written)
+	}
+	checkInvariants(t, cache)
+}
+
+func TestReadahead(t *testing.T) {
+
+	cache := New(10, Callbacks[int, int]{
+		GetValue: func(k int) (int, error) {
+			if k == 4 {
+				return 0, errors.New("unreadable")
+			}
+			return k * 10, nil
+		},
+	}, WithReadahead[int, int](func(k int) []int { return []int{k + 1, k + 2} }))
+	mu := &sync.Mutex{}
+	failed := make(chan int, 10)
+	stop := cache.StartReadahead(ReadaheadConfig[int]{
+		Locker:      mu,
+		Parallelism: 2,
+		OnError:     func(k int, err error) { failed <- k },
+	})
+	defer stop()
+
+	mu.Lock()
+	cache.Get(1)
+	cache.Get(3)
+	mu.Unlock()
+	cache.Hint(7)
+
+	if k := <-failed; k != 4 {
+		t.Fatalf("expected 4 to fail, got %d", k)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		mu.Lock()
+		n := len(cache.data)
+		mu.Unlock()
+		if n == 5 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("hinted keys were not loaded, have %d keys", n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	for _, k := range []int{1, 2, 3, 5, 7} {
+		if v, ok := cache.peek(k); !ok || v != k*10 {
+			t.Fatalf("expected %d to be cached, got %v %v", k, v, ok)
+		}
+	}
+	checkInvariants(t, cache)
+}
+
+func TestHintResize(t *testing.T) {
+
+	cache := New(10, Callbacks[int, int]{
+		GetValue: func(k int) (int, error) { return k, nil },
+	})
+	mu := &sync.Mutex{}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 1000; i += 1 {
+			cache.Hint(i)
+			cache.nextHint()
+		}
+	}()
+	for i := 0; i < 1000; i += 1 {
+		mu.Lock()
+		cache.Resize(1 + i%10)
+		mu.Unlock()
+	}
+	<-done
+
+	mu.Lock()
+	cache.Resize(2)
+	mu.Unlock()
+	cache.Hint(1, 2, 3, 4)
+	if len(cache.hints) != 2 {
+		t.Fatalf("expected hints to be limited by the new capacity, got %v", cache.hints)
+	}
+}
+
+func TestRefresher(t *testing.T) {
+
+	var version atomic.Int32
+	cache := New(4, Callbacks[int, int]{
+		GetValue: func(k int) (int, error) { return int(version.Load()), nil },
+	})
+	mu := &sync.Mutex{}
+	cache.Get(1)
+	cache.Get(2)
+
+	version.Store(1)
+	stop := cache.StartRefresher(RefresherConfig[int]{
+		Interval: time.Millisecond,
+		Locker:   mu,
+	})
+	defer stop()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		mu.Lock()
+		refreshed := cache.data[1].value == 1 && cache.data[2].value == 1
+		mu.Unlock()
+		if refreshed {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("entries were not refreshed")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestRefresherLoaderChain(t *testing.T) {
+
+	var version atomic.Int32
+	errBackend := errors.New("backend down")
+	cache := New(4, Callbacks[int, int]{
+		GetValue: func(k int) (int, error) {
+			if k == 2 && version.Load() > 0 {
+				return 0, ErrSkipCache
+			}
+			return int(version.Load()), nil
+		},
+	}, WithFaults[int, int](Faults[int]{
+		LoadError: func(k int) error {
+			if k == 3 && version.Load() > 0 {
+				return errBackend
+			}
+			return nil
+		},
+	}))
+	mu := &sync.Mutex{}
+	cache.Get(1)
+	cache.Get(2)
+	cache.Get(3)
+
+	var errs sync.Map
+	version.Store(1)
+	stop := cache.StartRefresher(RefresherConfig[int]{
+		Interval: time.Millisecond,
+		Locker:   mu,
+		OnError:  func(k int, err error) { errs.Store(k, err) },
+	})
+	defer stop()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		mu.Lock()
+		_, cached := cache.data[2]
+		refreshed := cache.data[1].value == 1 && !cached
+		mu.Unlock()
+		_, failed := errs.Load(3)
+		if refreshed && failed {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("entries were not refreshed")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if _, ok := errs.Load(2); ok {
+		t.Fatal("expected a skipped reload not to be an error")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if cache.data[3].value != 0 {
+		t.Fatal("expected a failed reload to keep the value")
+	}
+}
+
+func TestRefresherWriteBack(t *testing.T) {
+
+	var version atomic.Int32
+	written := map[int]int{}
+	cache := New(4, Callbacks[int, int]{
+		GetValue: func(k int) (int, error) { return int(version.Load()), nil },
+		WriteValue: func(k, v int) error {
+			written[k] = v
+			return nil
+		},
+	})
+	mu := &sync.Mutex{}
+	cache.Get(2)
+	// 1 is more recently used, so each pass reaches it before 2.
+	cache.Set(1, 5)
+
+	version.Store(100)
+
+	stop := cache.StartRefresher(RefresherConfig[int]{
+		Interval: time.Millisecond,
+		Locker:   mu,
+	})
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		mu.Lock()
+		refreshed := cache.data[2].value == 100
+		mu.Unlock()
+		if refreshed {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("entries were not refreshed")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	stop()
+
+	err := cache.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if written[1] != 5 {
+		t.Fatalf("refresher replaced a dirty value: %v", written)
+	}
+}
+
+func TestReplace(t *testing.T) {
+
+	cache := NewManual[int, int](10)
+
+	ok, err := cache.Replace(1, 10)
+	if ok || err != nil {
+		t.Fatal("expected replace of a missing key to do nothing")
+	}
+
+	cache.Set(1, 10)
+	ok, err = cache.Replace(1, 11)
+	if !ok || err != nil {
+		t.Fatal("expected replace to succeed")
+	}
+
+	if CompareAndSwap(cache.Cache(), 1, 10, 12) {
+		t.Fatal("expected swap with a stale value to fail")
+	}
+	if !CompareAndSwap(cache.Cache(), 1, 11, 12) {
+		t.Fatal("expected swap to succeed")
+	}
+	v, _ := cache.Get(1)
+	if v != 12 {
+		t.Fatalf("bad value: got=%d want=12", v)
+	}
+}
+
+func TestRange(t *testing.T) {
+
+	cache := NewManual[int, int](10)
+	for i := 0; i < 5; i += 1 {
+		cache.Set(i, i)
+	}
+
+	seen := 0
+	cache.Range(func(k, v int) bool {
+		seen += 1
+		return seen < 3
+	})
+	if seen != 3 {
+		t.Fatalf("bad visit count: got=%d want=3", seen)
+	}
+	// Range must not promote entries.
+	if cache.Cache().T2Len() != 0 {
+		t.Fatal("range promoted entries")
+	}
+
+	defer func() {
+		if r := recover(); r != ErrConcurrentModification {
+			t.Fatalf("expected ErrConcurrentModification panic, got %v", r)
+		}
+	}()
+	cache.Range(func(k, v int) bool {
+		cache.Get(k)
+		return true
+	})
+}
+
+func TestDeleteWhere(t *testing.T) {
+
+	evicted := []int{}
+
+	cache := New[int, int](100, Callbacks[int, int]{
+		GetValue: func(k int) (int, error) { return k, nil },
+		OnEvict: func(k, v int) error {
+			evicted = append(evicted, k)
+			return nil
+		},
+	})
+
+	for i := 0; i < 20; i += 1 {
+		cache.Get(i)
+	}
+
+	ok, err := cache.Delete(0)
+	if !ok || err != nil {
+		t.Fatal("expected delete to succeed")
+	}
+	ok, _ = cache.Delete(0)
+	if ok {
+		t.Fatal("expected second delete to do nothing")
+	}
+
+	n, err := cache.DeleteWhere(func(k, v int) bool { return k%2 == 0 })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 9 || len(evicted) != 10 {
+		t.Fatalf("bad delete count: got=%d evicted=%d", n, len(evicted))
+	}
+	if len(cache.data) != 10 || cache.t1.Len() != 10 || cache.b1.Len() != 0 {
+		t.Fatalf("bad cache state:\n%s", cache.DebugDump())
+	}
+
+	cache.Get(1)
+	cache.Pin(1)
+	_, err = cache.Delete(1)
+	if err != ErrPinned {
+		t.Fatalf("expected ErrPinned, got %v", err)
+	}
+}
+
+func TestInvalidateAsync(t *testing.T) {
+
+	version := 0
+
+	cache := New[int, int](10, Callbacks[int, int]{
+		GetValue: func(k int) (int, error) { return version, nil },
+	})
+
+	cache.Get(1)
+	cache.Get(2)
+	version = 1
+
+	done := make(chan struct{})
+	go func() {
+		cache.InvalidateAsync(1)
+		close(done)
+	}()
+	<-done
+
+	v, _ := cache.Get(1)
+	if v != 1 {
+		t.Fatal("expected invalidated key to be reloaded")
+	}
+	v, _ = cache.Get(2)
+	if v != 0 {
+		t.Fatal("expected other key to stay cached")
+	}
+
+	cache.Pin(2)
+	cache.InvalidateAsync(2)
+	v, _ = cache.Get(2)
+	if v != 0 {
+		t.Fatal("expected pinned key to stay cached")
+	}
+	cache.Unpin(2)
+	v, _ = cache.Get(2)
+	if v != 1 {
+		t.Fatal("expected key to be invalidated once unpinned")
+	}
+}
+
+func TestInvalidateGroup(t *testing.T) {
+
+	cache := New(4, Callbacks[string, int]{
+		GetValue: func(k string) (int, error) { return len(k), nil },
+	}, WithGroups[string, int](func(k string) string { return k[:1] }))
+
+	for _, k := range []string{"a1", "a2", "b1", "b2", "a3", "b3"} {
+		cache.Get(k)
+	}
+
+	n, err := cache.InvalidateGroup("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a1 and a2 were already evicted.
+	if n != 1 {
+		t.Fatalf("bad delete count: got=%d want=1", n)
+	}
+	for k := range cache.data {
+		if k[0] == 'a' {
+			t.Fatalf("%s survived group invalidation", k)
+		}
+	}
+	if _, ok := cache.groups["a"]; ok {
+		t.Fatal("expected empty group to be removed")
+	}
+	if len(cache.groups["b"]) != 3 {
+		t.Fatalf("bad group index: %v", cache.groups)
+	}
+
+	// Keys outside the group deleted through dependencies are not counted,
+	// members deleted through them are.
+	cache.Get("c1")
+	cache.AddDependency("c1", "b1")
+	cache.AddDependency("b3", "b1")
+	n, err = cache.InvalidateGroup("b")
+	if err != nil || n != 3 {
+		t.Fatalf("expected 3 members deleted, got %d %v", n, err)
+	}
+	if len(cache.data) != 0 {
+		t.Fatalf("expected the dependents to be deleted, got %v", cache.data)
+	}
+
+	_, err = cache.InvalidateGroup([]string{"a"})
+	if err == nil {
+		t.Fatal("expected a group of the wrong type to be rejected")
+	}
+	plain := New(4, Callbacks[string, int]{
+		GetValue: func(k string) (int, error) { return len(k), nil },
+	})
+	_, err = plain.InvalidateGroup("a")
+	if err != ErrNoGroups {
+		t.Fatalf("expected ErrNoGroups, got %v", err)
+	}
+	checkInvariants(t, cache)
+}
+
+func TestBumpGeneration(t *testing.T) {
+
+	version := 0
+
+	cache := New[int, int](10, Callbacks[int, int]{
+		GetValue: func(k int) (int, error) { return version, nil },
+	})
+
+	cache.Get(1)
+	cache.Get(2)
+	version = 1
+	cache.BumpGeneration()
+
+	cache.Range(func(k, v int) bool {
+		t.Fatal("range visited a stale entry")
+		return false
+	})
+
+	v, _ := cache.Get(1)
+	if v != 1 {
+		t.Fatal("expected stale entry to be reloaded")
+	}
+	v, _ = cache.Get(1)
+	if v != 1 {
+		t.Fatal("expected fresh entry to stay cached")
+	}
+
+	version = 2
+	cache.BumpGeneration()
+	v, _ = cache.Get(2)
+	if v != 2 {
+		t.Fatal("expected stale entry to be reloaded")
+	}
+}
+
+func TestBumpGenerationPinned(t *testing.T) {
+
+	version := 0
+	expired := []int{}
+	cache := New[int, int](2, Callbacks[int, int]{
+		GetValue: func(k int) (int, error) { return version, nil },
+		OnExpire: func(k, v int) error {
+			expired = append(expired, v)
+			return nil
+		},
+	})
+
+	cache.Get(1)
+	cache.Get(1)
+	cache.Get(2)
+	cache.Pin(1)
+	before := cache.Inspect()
+	version = 1
+	cache.BumpGeneration()
+
+	v, err := cache.Get(1)
+	if err != nil || v != 1 {
+		t.Fatalf("expected the pinned entry to be reloaded, got %v %v", v, err)
+	}
+	if fmt.Sprint(cache.Inspect()) != fmt.Sprint(before) {
+		t.Fatalf("reload moved the pinned entry: %v %v", before, cache.Inspect())
+	}
+	if len(expired) != 1 || expired[0] != 0 {
+		t.Fatalf("expected the stale value to expire, got %v", expired)
+	}
+
+	cache.BumpGeneration()
+	err = cache.Set(1, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := cache.Get(1); v != 5 {
+		t.Fatalf("expected the set value, got %v", v)
+	}
+
+	// The pin survives the reloads.
+	for i := 10; i < 20; i += 1 {
+		cache.Get(i)
+	}
+	if _, ok := cache.peek(1); !ok {
+		t.Fatal("pinned key was evicted")
+	}
+	cache.Unpin(1)
+	checkInvariants(t, cache)
+}
+
+func TestTouch(t *testing.T) {
+
+	cache := NewManual[int, int](10)
+
+	if cache.Touch(1) {
+		t.Fatal("expected touch of a missing key to fail")
+	}
+	cache.Set(1, 1)
+	if !cache.Touch(1) {
+		t.Fatal("expected touch to succeed")
+	}
+	if cache.Cache().T2Len() != 1 {
+		t.Fatal("expected touch to promote to t2")
+	}
+}
+
+// countingGhosts wraps the default ghosts to check the cache drives
+// custom implementations correctly.
+type countingGhosts struct {
+	*listGhosts[int]
+	adds int
+}
+
+func (g *countingGhosts) Add(key int) {
+	g.adds += 1
+	g.listGhosts.Add(key)
+}
+
+func TestCustomGhosts(t *testing.T) {
+
+	ghosts := []*countingGhosts{}
+
+	cache := New(5, Callbacks[int, int]{
+		GetValue: func(k int) (int, error) { return k, nil },
+	}, WithGhosts[int, int](func() Ghosts[int] {
+		g := &countingGhosts{listGhosts: newListGhosts[int](0)}
+		ghosts = append(ghosts, g)
+		return g
+	}))
+
+	for i := 0; i < 1000; i += 1 {
+		cache.Get(rand.Int() % 20)
+	}
+
+	if len(ghosts) != 2 || ghosts[0].adds == 0 || ghosts[1].adds == 0 {
+		t.Fatal("expected custom ghosts to be used")
+	}
+	if cache.T1Len()+cache.T2Len()+cache.B1Len()+cache.B2Len() > 10 {
+		t.Fatal("ghosts exceeded their budget")
+	}
+}
+
+func TestFingerprintGhosts(t *testing.T) {
+
+	getValue := func(k int) (int, error) { return k, nil }
+
+	exact := New(10, Callbacks[int, int]{GetValue: getValue})
+	fingerprinted := New(10, Callbacks[int, int]{GetValue: getValue},
+		WithGhosts[int, int](func() Ghosts[int] {
+			return NewFingerprintGhosts(func(k int) uint64 { return uint64(k) * 0x9e3779b97f4a7c15 })
+		}))
+
+	for i := 0; i < 10000; i += 1 {
+		k := rand.Int() % 50
+		exact.Get(k)
+		fingerprinted.Get(k)
+	}
+
+	// Without collisions the caches must behave identically.
+	s1, s2 := exact.Inspect(), fingerprinted.Inspect()
+	if s2.B1 != nil || s2.B2 != nil {
+		t.Fatal("fingerprint ghosts cannot list keys")
+	}
+	if s1.Partition != s2.Partition || len(s1.T1) != len(s2.T1) || len(s1.T2) != len(s2.T2) {
+		t.Fatalf("caches diverged:\n%s\n%s", exact.DebugDump(), fingerprinted.DebugDump())
+	}
+	for i := range s1.T1 {
+		if s1.T1[i] != s2.T1[i] {
+			t.Fatal("t1 diverged")
+		}
+	}
+	for i := range s1.T2 {
+		if s1.T2[i] != s2.T2[i] {
+			t.Fatal("t2 diverged")
+		}
+	}
+}
+
+func TestDebugJSON(t *testing.T) {
+
+	cache := NewManual[string, int](2)
+	cache.Set("a", 1)
+	cache.Set("b", 2)
+	cache.Get("a")
+	cache.Get("c")
+
+	buf, err := cache.Cache().DebugJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"capacity":2,"partition":0,"t1":["b"],"t2":["a"],"b1":[],"b2":[],"stats":{"hits":1,"misses":1,"b1_hits":0,"b2_hits":0}}`
+	if string(buf) != expected {
+		t.Fatalf("bad json:\n got=%s\nwant=%s", buf, expected)
+	}
+}
+
+type testLogger struct {
+	msgs []string
+}
+
+func (l *testLogger) Debug(msg string, args ...any) {
+	l.msgs = append(l.msgs, strings.TrimSpace(fmt.Sprintln(append([]any{msg}, args...)...)))
+}
+
+func TestLogger(t *testing.T) {
+
+	logger := &testLogger{}
+
+	cache := New(1, Callbacks[int, int]{
+		GetValue: func(k int) (int, error) {
+			if k < 0 {
+				return 0, errors.New("negative")
+			}
+			return k, nil
+		},
+	}, WithLogger[int, int](logger))
+
+	cache.Get(1)
+	cache.Get(2)
+	cache.Get(-1)
+
+	expected := []string{
+		"cache evict key 1",
+		"cache load failed key -1 err negative",
+	}
+	if len(logger.msgs) != len(expected) {
+		t.Fatalf("bad logs: %q", logger.msgs)
+	}
+	for i := range expected {
+		if logger.msgs[i] != expected[i] {
+			t.Fatalf("bad logs: %q", logger.msgs)
+		}
+	}
+}
+
+type recordingListener struct {
+	NopListener[int, int]
+	events []string
+}
+
+func (l *recordingListener) OnAdd(k, v int)       { l.record("add", k) }
+func (l *recordingListener) OnHit(k, v int)       { l.record("hit", k) }
+func (l *recordingListener) OnMiss(k int)         { l.record("miss", k) }
+func (l *recordingListener) OnEvict(k, v int)     { l.record("evict", k) }
+func (l *recordingListener) OnExpire(k, v int)    { l.record("expire", k) }
+func (l *recordingListener) OnUpdate(k, o, n int) { l.record("update", k) }
+
+func (l *recordingListener) record(event string, k int) {
+	l.events = append(l.events, fmt.Sprintf("%s %d", event, k))
+}
+
+// opListener records the operation ID of each event.
+type opListener struct {
+	NopListener[int, int]
+	cache *Cache[int, int]
+	ops   []uint64
+}
+
+func (l *opListener) OnAdd(k, v int)    { l.ops = append(l.ops, l.cache.OpID()) }
+func (l *opListener) OnMiss(k int)      { l.ops = append(l.ops, l.cache.OpID()) }
+func (l *opListener) OnEvict(k, v int)  { l.ops = append(l.ops, l.cache.OpID()) }
+func (l *opListener) OnExpire(k, v int) { l.ops = append(l.ops, l.cache.OpID()) }
+
+func TestOpID(t *testing.T) {
+
+	l := &opListener{}
+	cache := New(1, Callbacks[int, int]{
+		GetValue: func(k int) (int, error) { return k, nil },
+	}, WithEventListener[int, int](l))
+	l.cache = cache
+
+	cache.Get(1)
+	cache.Get(2)
+	cache.Set(3, 3)
+	cache.Delete(3)
+	// miss and add, miss, evict and add, evict and add, evict.
+	expected := []uint64{1, 1, 2, 2, 2, 3, 3, 4}
+	if fmt.Sprint(l.ops) != fmt.Sprint(expected) {
+		t.Fatalf("bad operation IDs:\n got=%v\nwant=%v", l.ops, expected)
+	}
+	if cache.OpID() != 4 {
+		t.Fatalf("expected the last operation ID, got %d", cache.OpID())
+	}
+}
+
+func TestEventListener(t *testing.T) {
+
+	l := &recordingListener{}
+
+	cache := New(1, Callbacks[int, int]{
+		GetValue: func(k int) (int, error) { return k, nil },
+	}, WithEventListener[int, int](l))
+
+	cache.Get(1)
+	cache.Get(1)
+	cache.Set(1, 10)
+	cache.Get(2)
+	cache.BumpGeneration()
+	cache.Get(2)
+	cache.Delete(2)
+
+	expected := []string{
+		"miss 1", "add 1",
+		"hit 1",
+		"update 1",
+		"miss 2", "evict 1", "add 2",
+		"expire 2", "miss 2", "add 2",
+		"evict 2",
+	}
+	if strings.Join(l.events, ",") != strings.Join(expected, ",") {
+		t.Fatalf("bad events:\n got=%v\nwant=%v", l.events, expected)
+	}
+}
+
+// checkInvariants verifies the ARC list bounds and that the internal
+// structures agree with each other.
+func checkInvariants[K comparable, V any](t testing.TB, c *Cache[K, V]) {
+	t1, t2, b1, b2 := c.t1.Len(), c.t2.Len(), c.b1.Len(), c.b2.Len()
+	if t1+t2 > c.cap {
+		t.Fatalf("resident entries exceed capacity:\n%s", c.DebugDump())
+	}
+	if t1+b1 > max(c.cap, c.ghostCap) {
+		t.Fatalf("t1 and b1 exceed capacity:\n%s", c.DebugDump())
+	}
+	if t1+t2+b1+b2 > c.cap+c.ghostCap {
+		t.Fatalf("lists exceed capacity and ghost capacity:\n%s", c.DebugDump())
+	}
+	if c.part < 0 || c.part > c.cap {
+		t.Fatalf("partition out of range:\n%s", c.DebugDump())
+	}
+	if len(c.data) != t1+t2 {
+		t.Fatalf("data and lists disagree:\n%s", c.DebugDump())
+	}
+	for k, e := range c.data {
+		if e.key != k || (e.list != c.t1 && e.list != c.t2) {
+			t.Fatalf("%v must be in exactly one of t1 and t2:\n%s", k, c.DebugDump())
+		}
+		if c.b1.Contains(k) || c.b2.Contains(k) {
+			t.Fatalf("%v is both resident and a ghost:\n%s", k, c.DebugDump())
+		}
+	}
+	dirty := 0
+	for _, e := range c.data {
+		if e.dirty {
+			dirty += 1
+		}
+	}
+	if dirty != c.dirtyLen {
+		t.Fatalf("%d entries are dirty, want %d", dirty, c.dirtyLen)
+	}
+	if c.groupOf != nil {
+		n := 0
+		for _, members := range c.groups {
+			n += len(members)
+		}
+		if n != len(c.data) {
+			t.Fatalf("group index has %d keys, want %d", n, len(c.data))
+		}
+	}
+}
+
+func TestEvictionChannel(t *testing.T) {
+
+	cache := New(2, Callbacks[int, int]{
+		GetValue: func(k int) (int, error) { return k * 10, nil },
+	}, WithEvictionChannel[int, int](10))
+
+	for i := 0; i < 5; i += 1 {
+		cache.Get(i)
+	}
+	cache.Delete(4)
+
+	for _, want := range []int{0, 1, 2, 4} {
+		e := <-cache.Evictions()
+		if e.Key != want || e.Value != want*10 {
+			t.Fatalf("bad eviction: got=%+v want=%d", e, want)
+		}
+	}
+	select {
+	case e := <-cache.Evictions():
+		t.Fatalf("unexpected eviction: %+v", e)
+	default:
+	}
+}
+
+func TestHighWater(t *testing.T) {
+
+	cache := NewManual[int, int](10)
+	for i := 0; i < 8; i += 1 {
+		cache.Set(i, i)
+	}
+	cache.Cache().Trim(6)
+	cache.Cache().ResetHighWater()
+	cache.Set(100, 100)
+
+	total, sinceReset := cache.Cache().HighWater()
+	if total != 8 || sinceReset != 3 {
+		t.Fatalf("bad high water: got=%d,%d want=8,3", total, sinceReset)
+	}
+}
+
+func TestPanicRecovery(t *testing.T) {
+
+	callbacks := Callbacks[int, int]{
+		GetValue: func(k int) (int, error) { return k, nil },
+		OnEvict: func(k int, v int) error {
+			if k == 0 {
+				panic("evict")
+			}
+			return nil
+		},
+	}
+	cache := New(2, callbacks, WithPanicRecovery[int, int]())
+	for i := 0; i < 2; i += 1 {
+		_, err := cache.Get(i)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	before := cache.DebugDump()
+	_, err := cache.Get(2)
+	var panicErr *PanicError
+	if !errors.As(err, &panicErr) || panicErr.Value != "evict" {
+		t.Fatalf("expected a recovered panic, got %v", err)
+	}
+	if after := cache.DebugDump(); after != before {
+		t.Fatalf("cache changed after panic:\n%s\n%s", before, after)
+	}
+
+	_, err = cache.GetOrCompute(3, func() (int, error) { panic("load") })
+	if !errors.As(err, &panicErr) || panicErr.Value != "load" {
+		t.Fatalf("expected a recovered panic, got %v", err)
+	}
+}
+
+func TestEvictionRetry(t *testing.T) {
+
+	failing := true
+	evicted := []int{}
+	callbacks := Callbacks[int, int]{
+		GetValue: func(k int) (int, error) { return k, nil },
+		OnEvict: func(k int, v int) error {
+			if failing {
+				return errors.New("evict failed")
+			}
+			evicted = append(evicted, k)
+			return nil
+		},
+	}
+	clock := fakeclock.New(time.Unix(1000, 0))
+	cache := New(2, callbacks, WithEvictionRetry[int, int](time.Second, time.Hour), WithClock[int, int](clock))
+	for i := 0; i < 4; i += 1 {
+		_, err := cache.Get(i)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, ok := cache.data[0]; ok {
+		t.Fatal("expected 0 to be evicted despite OnEvict failing")
+	}
+
+	n, err := cache.RetryEvictions()
+	if n != 2 || err != nil || len(evicted) != 0 {
+		t.Fatalf("retried before backoff: n=%d err=%v evicted=%v", n, err, evicted)
+	}
+	clock.Advance(time.Second)
+	n, err = cache.RetryEvictions()
+	if n != 2 || err == nil {
+		t.Fatalf("expected due evictions to be retried and fail: n=%d err=%v", n, err)
+	}
+	clock.Advance(time.Second)
+	n, _ = cache.RetryEvictions()
+	if n != 2 || len(evicted) != 0 {
+		t.Fatalf("retried before doubled backoff: n=%d", n)
+	}
+
+	failing = false
+	err = cache.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(evicted) != 2 || evicted[0] != 0 || evicted[1] != 1 {
+		t.Fatalf("bad retried evictions: %v", evicted)
+	}
+}
+
+func TestEvictErrorPolicy(t *testing.T) {
+
+	evicts := 0
+	callbacks := Callbacks[int, int]{
+		GetValue: func(k int) (int, error) { return k, nil },
+		OnEvict: func(k int, v int) error {
+			evicts += 1
+			return errors.New("evict failed")
+		},
+	}
+
+	failing := New(1, callbacks)
+	failing.Get(0)
+	if _, err := failing.Get(1); err == nil {
+		t.Fatal("expected OnEvict error to fail Get")
+	}
+
+	ignoring := New(1, callbacks, WithEvictErrorPolicy[int, int](EvictErrorIgnore))
+	ignoring.Get(0)
+	if _, err := ignoring.Get(1); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := ignoring.RetryEvictions(); n != 0 {
+		t.Fatalf("ignored eviction was queued")
+	}
+
+	retrying := New(1, callbacks, WithEvictErrorPolicy[int, int](EvictErrorRetry))
+	retrying.Get(0)
+	if _, err := retrying.Get(1); err != nil {
+		t.Fatal(err)
+	}
+	evicts = 0
+	if err := retrying.Close(); err == nil || evicts != 1 {
+		t.Fatalf("expected the queued eviction to be retried, err=%v evicts=%d", err, evicts)
+	}
+}
+
+func TestExpiryPolicies(t *testing.T) {
+
+	now := time.Unix(1000, 0)
+	written := now.Add(-30 * time.Second)
+	expires := now.Add(10 * time.Second)
+	at := func(d time.Duration) time.Time { return now.Add(d) }
+
+	tests := []struct {
+		name                 string
+		policy               ExpiryPolicy[int, int]
+		create, update, read time.Time
+	}{
+		{"ttl", TTL[int, int](time.Minute), at(time.Minute), at(time.Minute), expires},
+		{"tti", TTI[int, int](time.Minute), at(time.Minute), at(time.Minute), at(time.Minute)},
+		{"ttl and tti", TTLAndTTI[int, int](time.Minute, 40*time.Second), at(40 * time.Second), at(40 * time.Second), at(30 * time.Second)},
+		{"never", TTL[int, int](0), time.Time{}, time.Time{}, expires},
+		{"func", ExpiryFunc[int, int](func(k, v int) time.Duration { return time.Duration(v) * time.Second }), at(5 * time.Second), at(5 * time.Second), expires},
+	}
+	for _, tc := range tests {
+		if got := tc.policy.ExpireAfterCreate(1, 5, now); !got.Equal(tc.create) {
+			t.Errorf("%s: bad create expiry: %v", tc.name, got)
+		}
+		if got := tc.policy.ExpireAfterUpdate(1, 5, now, written, expires); !got.Equal(tc.update) {
+			t.Errorf("%s: bad update expiry: %v", tc.name, got)
+		}
+		if got := tc.policy.ExpireAfterRead(1, 5, now, written, expires); !got.Equal(tc.read) {
+			t.Errorf("%s: bad read expiry: %v", tc.name, got)
+		}
+	}
+}
+
+func TestRemoveExpired(t *testing.T) {
+
+	clock := fakeclock.New(time.Unix(1000, 0))
+	lifetime := func(k, v int) time.Duration { return time.Duration(k) * time.Second }
+	cache := NewManual[int, int](10,
+		WithExpiry[int, int](ExpiryFunc[int, int](lifetime)),
+		WithClock[int, int](clock),
+	)
+	for i := 0; i < 5; i += 1 {
+		cache.Set(i, i)
+	}
+	cache.Pin(1)
+
+	clock.Advance(3 * time.Second)
+	n, err := cache.Cache().RemoveExpired()
+	if err != nil || n != 2 {
+		t.Fatalf("expected 2 and 3 to be removed, got %d %v", n, err)
+	}
+	if _, ok := cache.Cache().data[1]; !ok {
+		t.Fatal("pinned entry was removed")
+	}
+	if cache.Len() != 3 || len(cache.Cache().expiryHeap) != 2 {
+		t.Fatalf("bad state after expiry: %d %d", cache.Len(), len(cache.Cache().expiryHeap))
+	}
+
+	cache.Unpin(1)
+	clock.Advance(time.Hour)
+	n, err = cache.Cache().RemoveExpired()
+	if err != nil || n != 2 || cache.Len() != 1 {
+		t.Fatalf("expected all but 0 to expire, got %d %v %d", n, err, cache.Len())
+	}
+}
+
+func TestSetExpiry(t *testing.T) {
+
+	clock := fakeclock.New(time.Unix(0, 0))
+	loads := 0
+	cache := New(4, Callbacks[int, int]{
+		GetValue: func(k int) (int, error) {
+			loads += 1
+			return k, nil
+		},
+	}, WithClock[int, int](clock), WithExpiry(TTL[int, int](time.Minute)))
+
+	if cache.ExtendTTL(1, time.Hour) {
+		t.Fatal("expected extending a missing key to fail")
+	}
+	cache.Get(1)
+	cache.Get(2)
+	cache.Get(3)
+	clock.Advance(30 * time.Second)
+	if !cache.ExtendTTL(1, time.Hour) {
+		t.Fatal("expected extend to succeed")
+	}
+	cache.SetExpiry(2, time.Time{})
+	cache.SetExpiry(3, clock.Now().Add(time.Second))
+
+	clock.Advance(2 * time.Second)
+	if n, err := cache.RemoveExpired(); n != 1 || err != nil {
+		t.Fatalf("expected only 3 to expire, got %d %v", n, err)
+	}
+	clock.Advance(time.Minute)
+	if n, _ := cache.RemoveExpired(); n != 0 {
+		t.Fatalf("expected extended keys to be kept, got %d removed", n)
+	}
+	if info, _ := cache.EntryInfo(1); info.TTL != time.Hour-62*time.Second {
+		t.Fatalf("bad extended ttl: %v", info.TTL)
+	}
+	if loads != 3 {
+		t.Fatalf("expected no reloads, got %d loads", loads)
+	}
+
+	plain := NewManual[int, int](2)
+	plain.Set(1, 1)
+	if plain.Cache().ExtendTTL(1, time.Hour) {
+		t.Fatal("expected extend without an expiry policy to fail")
+	}
+}
+
+func TestExpiryPinned(t *testing.T) {
+
+	clock := fakeclock.New(time.Unix(0, 0))
+	loads := 0
+	cache := New(2, Callbacks[int, int]{
+		GetValue: func(k int) (int, error) {
+			loads += 1
+			return loads, nil
+		},
+	}, WithClock[int, int](clock), WithExpiry(TTL[int, int](time.Minute)))
+
+	cache.Get(1)
+	cache.Pin(1)
+	clock.Advance(time.Minute)
+	if _, ok := cache.EntryInfo(1); ok {
+		t.Fatal("expected the pinned entry to expire")
+	}
+	if n, err := cache.RemoveExpired(); n != 0 || err != nil {
+		t.Fatalf("expected the pinned entry to be skipped, got %v %v", n, err)
+	}
+
+	v, err := cache.Get(1)
+	if err != nil || v != 2 {
+		t.Fatalf("expected the expired pinned entry to be reloaded, got %v %v", v, err)
+	}
+	if info, _ := cache.EntryInfo(1); info.TTL != time.Minute {
+		t.Fatalf("expected the reloaded entry to expire afresh, got %v", info.TTL)
+	}
+
+	clock.Advance(time.Minute)
+	err = cache.Set(1, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := cache.Get(1); v != 10 {
+		t.Fatalf("expected the set value, got %v", v)
+	}
+	if _, err := cache.Delete(1); err != ErrPinned {
+		t.Fatalf("expected the entry to stay pinned, got %v", err)
+	}
+	checkInvariants(t, cache)
+}
+
+func TestOnExpire(t *testing.T) {
+
+	events := []string{}
+	clock := fakeclock.New(time.Unix(1000, 0))
+	cache := New(2, Callbacks[int, int]{
+		GetValue: func(k int) (int, error) { return k, nil },
+		OnEvict: func(k, v int) error {
+			events = append(events, fmt.Sprintf("evict %d", k))
+			return nil
+		},
+		OnExpire: func(k, v int) error {
+			events = append(events, fmt.Sprintf("expire %d", k))
+			return nil
+		},
+	}, WithExpiry[int, int](TTL[int, int](time.Second)), WithClock[int, int](clock))
+
+	cache.Get(1)
+	cache.Get(2)
+	cache.Get(3)
+	clock.Advance(time.Second)
+	cache.Get(2)
+	cache.RemoveExpired()
+
+	if strings.Join(events, ",") != "evict 1,expire 2,expire 3" {
+		t.Fatalf("bad events: %v", events)
+	}
+}
+
+func TestEntryInfo(t *testing.T) {
+
+	start := time.Unix(1000, 0)
+	clock := fakeclock.New(start)
+	cache := NewManual[int, int](4,
+		WithEntryStats[int, int](),
+		WithExpiry[int, int](TTL[int, int](time.Minute)),
+		WithClock[int, int](clock),
+	)
+	cache.Set(1, 1)
+	clock.Advance(time.Second)
+	cache.Get(1)
+	clock.Advance(time.Second)
+	cache.Get(1)
+
+	info, ok := cache.Cache().EntryInfo(1)
+	want := Info{
+		List:       "T2",
+		Added:      start,
+		LastAccess: start.Add(2 * time.Second),
+		Hits:       2,
+		Expires:    start.Add(time.Minute),
+		TTL:        58 * time.Second,
+	}
+	if !ok || info != want {
+		t.Fatalf("bad entry info: %+v", info)
+	}
+	if _, ok := cache.Cache().EntryInfo(2); ok {
+		t.Fatal("expected no info for a missing key")
+	}
+}
+
+func TestTopKeys(t *testing.T) {
+
+	cache := NewManual[int, int](10, WithEntryStats[int, int]())
+	for i := 0; i < 5; i += 1 {
+		cache.Set(i, i)
+		for j := 0; j < i; j += 1 {
+			cache.Get(i)
+		}
+	}
+	top := cache.Cache().TopKeys(2)
+	want := []KeyStats[int]{{Key: 4, Hits: 4}, {Key: 3, Hits: 3}}
+	if len(top) != 2 || top[0] != want[0] || top[1] != want[1] {
+		t.Fatalf("bad top keys: %v", top)
+	}
+	if NewManual[int, int](10).Cache().TopKeys(2) != nil {
+		t.Fatal("expected no top keys without entry stats")
+	}
+}
+
+func TestRecentHitRatio(t *testing.T) {
+
+	cache := NewManual[int, int](10, WithHitRatioWindow[int, int](10))
+	if cache.Cache().RecentHitRatio() != 0 {
+		t.Fatal("expected zero ratio before any lookups")
+	}
+	cache.Set(1, 1)
+	cache.Get(1)
+	if cache.Cache().RecentHitRatio() != 1 {
+		t.Fatalf("expected an unbiased first lookup, got %v", cache.Cache().RecentHitRatio())
+	}
+	for i := 0; i < 100; i += 1 {
+		cache.Get(1)
+	}
+	for i := 0; i < 100; i += 1 {
+		cache.Get(2)
+	}
+	if cache.Stats().HitRatio() < 0.5 {
+		t.Fatal("expected lifetime ratio to remember the hits")
+	}
+	if cache.Cache().RecentHitRatio() > 0.01 {
+		t.Fatalf("expected recent ratio to follow the misses, got %v", cache.Cache().RecentHitRatio())
+	}
+}
+
+func TestGhostHitStats(t *testing.T) {
+
+	cache := NewManual[int, int](2)
+	cache.Set(1, 1)
+	cache.Get(1)
+	cache.Set(2, 2)
+	cache.Set(3, 3) // Evicts 2 into B1.
+	cache.Set(2, 2) // Hits B1, evicting 1 into B2.
+	cache.Set(1, 1) // Hits B2.
+
+	stats := cache.Stats()
+	if stats.B1Hits != 1 || stats.B2Hits != 1 {
+		t.Fatalf("bad ghost hit stats: %+v\n%s", stats, cache.Cache().DebugDump())
+	}
+}
+
+func TestGhostRatio(t *testing.T) {
+
+	for _, ratio := range []float64{0.5, 1, 4} {
+		cache := NewManual[int, int](20, WithGhostRatio[int, int](ratio))
+		ghostCap := int(ratio * 20)
+		r := rand.New(rand.NewSource(1))
+		maxGhosts := 0
+		for i := 0; i < 10000; i += 1 {
+			k := r.Intn(200)
+			if _, ok := cache.Get(k); !ok {
+				cache.Set(k, k)
+			}
+			checkInvariants(t, cache.Cache())
+			maxGhosts = max(maxGhosts, cache.Cache().B1Len()+cache.Cache().B2Len())
+		}
+		if maxGhosts != ghostCap {
+			t.Fatalf("ratio %v: expected up to %d ghosts, got %d", ratio, ghostCap, maxGhosts)
+		}
+	}
+}
+
+func TestWithoutGhosts(t *testing.T) {
+
+	cache := NewManual[int, int](4, WithoutGhosts[int, int]())
+	cache.Set(0, 0)
+	cache.Get(0)
+	for i := 1; i < 100; i += 1 {
+		cache.Set(i, i)
+		checkInvariants(t, cache.Cache())
+	}
+	if cache.Cache().B1Len() != 0 || cache.Cache().B2Len() != 0 {
+		t.Fatal("expected no ghosts")
+	}
+	if _, ok := cache.Get(0); !ok {
+		t.Fatal("expected a frequently used entry to survive a scan")
+	}
+}
+
+func TestResize(t *testing.T) {
+
+	cache := NewManual[int, int](10)
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 1000; i += 1 {
+		k := r.Intn(30)
+		if _, ok := cache.Get(k); !ok {
+			cache.Set(k, k)
+		}
+	}
+	for _, size := range []int{3, 1, 20, 5} {
+		err := cache.Resize(size)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkInvariants(t, cache.Cache())
+		for i := 0; i < 100; i += 1 {
+			k := r.Intn(30)
+			if _, ok := cache.Get(k); !ok {
+				cache.Set(k, k)
+			}
+			checkInvariants(t, cache.Cache())
+		}
+		if cache.Len() != size && size != 20 {
+			t.Fatalf("expected a full cache of %d, got %d", size, cache.Len())
+		}
+	}
+	if cache.Resize(0) == nil {
+		t.Fatal("expected resize to zero to fail")
+	}
+}
+
+func TestAdjustCapacity(t *testing.T) {
+
+	cache := NewManual[int, int](10, WithHitRatioWindow[int, int](10))
+	goal := CapacityGoal{HitRatio: 0.5, Tolerance: 0.2, Min: 5, Max: 12}
+	for i := 0; i < 20; i += 1 {
+		cache.Get(i)
+	}
+	for _, want := range []int{11, 12, 12} {
+		size, err := cache.Cache().AdjustCapacity(goal)
+		if err != nil || size != want {
+			t.Fatalf("expected growth to %d, got %d %v", want, size, err)
+		}
+	}
+	cache.Set(1, 1)
+	for i := 0; i < 20; i += 1 {
+		cache.Get(1)
+	}
+	size, err := cache.Cache().AdjustCapacity(goal)
+	if err != nil || size != 11 || cache.Capacity() != 11 {
+		t.Fatalf("expected shrinking to 11, got %d %v", size, err)
+	}
+
+	// A byte budget shrinks the cache despite misses, and stops growth.
+	logger := &testLogger{}
+	sized := NewManual(10, WithHitRatioWindow[int, []byte](10),
+		WithSizer(func(_ int, v []byte) int { return cap(v) }),
+		WithLogger[int, []byte](logger))
+	for i := 0; i < 10; i += 1 {
+		sized.Set(i, make([]byte, 100))
+	}
+	for i := 10; i < 20; i += 1 {
+		sized.Get(i)
+	}
+	budget := sized.Cache().EstimatedBytes() - 1
+	goal = CapacityGoal{HitRatio: 0.5, Min: 5, Max: 20, MaxBytes: budget}
+	size, err = sized.Cache().AdjustCapacity(goal)
+	if err != nil || size != 9 {
+		t.Fatalf("expected shrinking to 9 over budget, got %d %v", size, err)
+	}
+	if sized.Cache().EstimatedBytes() > budget {
+		t.Fatal("expected the cache to be within budget")
+	}
+	size, err = sized.Cache().AdjustCapacity(goal)
+	if err != nil || size != 9 {
+		t.Fatalf("expected no growth past the budget, got %d %v", size, err)
+	}
+	resizes := []string{}
+	for _, msg := range logger.msgs {
+		if strings.HasPrefix(msg, "cache resize") {
+			resizes = append(resizes, msg)
+		}
+	}
+	if len(resizes) != 1 || !strings.HasPrefix(resizes[0], "cache resize from 10 to 9 hit_ratio 0 bytes ") {
+		t.Fatalf("bad logs: %q", logger.msgs)
+	}
+	if _, err := sized.Cache().AdjustCapacity(CapacityGoal{Min: 1, Max: 2, MaxBytes: -1}); err == nil {
+		t.Fatal("expected a negative budget to fail")
+	}
+}
+
+func TestEstimatedBytes(t *testing.T) {
+
+	cache := NewManual[int, int](10)
+	if n := cache.Cache().EstimatedBytes(); n != 0 {
+		t.Fatalf("expected an empty cache to use nothing, got %d", n)
+	}
+	prev := int64(0)
+	for i := 0; i < 10; i += 1 {
+		cache.Set(i, i)
+		n := cache.Cache().EstimatedBytes()
+		if n <= prev {
+			t.Fatalf("expected the estimate to grow, got %d after %d", n, prev)
+		}
+		prev = n
+	}
+	// Promote a key so the next eviction leaves a ghost.
+	cache.Get(0)
+	prev = cache.Cache().EstimatedBytes()
+	cache.Set(10, 10)
+	if n := cache.Cache().EstimatedBytes(); n <= prev {
+		t.Fatalf("expected ghosts to be counted, got %d after %d", n, prev)
+	}
+
+	// A sizer adds what keys and values point to.
+	shallow := NewManual[string, []byte](10)
+	sized := NewManual(10, WithSizer(func(k string, v []byte) int {
+		return len(k) + cap(v)
+	}))
+	for _, c := range []*Manual[string, []byte]{shallow, sized} {
+		c.Set("key", make([]byte, 1000))
+		c.Set("other", nil)
+	}
+	diff := sized.Cache().EstimatedBytes() - shallow.Cache().EstimatedBytes()
+	if diff != 1008 {
+		t.Fatalf("expected the sizer to add 1008 bytes, got %d", diff)
+	}
+}
+
+func TestJitter(t *testing.T) {
+
+	now := time.Unix(1000, 0)
+	policy := Jitter(TTL[int, int](100*time.Second), 0.5)
+	seen := make(map[time.Time]struct{})
+	for i := 0; i < 100; i += 1 {
+		expires := policy.ExpireAfterCreate(i, i, now)
+		if expires.Before(now.Add(50*time.Second)) || expires.After(now.Add(100*time.Second)) {
+			t.Fatalf("jittered expiry out of range: %v", expires.Sub(now))
+		}
+		seen[expires] = struct{}{}
+		if read := policy.ExpireAfterRead(i, i, now, now, expires); !read.Equal(expires) {
+			t.Fatalf("read changed a ttl expiry: %v %v", expires, read)
+		}
+	}
+	if len(seen) < 2 {
+		t.Fatal("expected expiries to be spread")
+	}
+}
+
+func TestExpiry(t *testing.T) {
+
+	loads := 0
+	callbacks := Callbacks[int, int]{
+		GetValue: func(k int) (int, error) {
+			loads += 1
+			return k, nil
+		},
+	}
+	listener := &recordingListener{}
+	lifetime := func(k, v int) time.Duration {
+		if k == 0 {
+			return time.Nanosecond
+		}
+		return 0
+	}
+	clock := fakeclock.New(time.Unix(1000, 0))
+	cache := New(4, callbacks,
+		WithExpiry[int, int](ExpiryFunc[int, int](lifetime)),
+		WithEventListener[int, int](listener),
+		WithClock[int, int](clock),
+	)
+	for i := 0; i < 2; i += 1 {
+		_, err := cache.Get(i)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	clock.Advance(time.Nanosecond)
+
+	cache.Range(func(k, v int) bool {
+		if k == 0 {
+			t.Fatal("range visited an expired entry")
+		}
+		return true
+	})
+	for i := 0; i < 2; i += 1 {
+		_, err := cache.Get(i)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if loads != 3 {
+		t.Fatalf("expected only the expired entry to be reloaded, got %d loads", loads)
+	}
+	expireEvents := []string{}
+	for _, event := range listener.events {
+		if strings.HasPrefix(event, "expire") {
+			expireEvents = append(expireEvents, event)
+		}
+	}
+	if len(expireEvents) != 1 || expireEvents[0] != "expire 0" {
+		t.Fatalf("bad expire events: %v", expireEvents)
+	}
+}
+
+func TestReplication(t *testing.T) {
+
+	feed := NewReplicationFeed[int, int](100)
+	primary := NewManual[int, int](3, WithEventListener[int, int](feed))
+	standby := NewManual[int, int](3)
+	replica := NewReplica(standby.Cache())
+
+	drain := func() {
+		for {
+			select {
+			case ev := <-feed.Events():
+				err := replica.Apply(ev)
+				if err != nil {
+					t.Fatal(err)
+				}
+			default:
+				return
+			}
+		}
+	}
+	for k := 0; k < 5; k += 1 {
+		primary.Set(k, k)
+	}
+	primary.Set(3, 30)
+	primary.Delete(4)
+	drain()
+	for k := 0; k < 5; k += 1 {
+		want, wantOK := primary.Peek(k)
+		got, gotOK := standby.Peek(k)
+		if got != want || gotOK != wantOK {
+			t.Fatalf("key %d: standby has %d, %v, primary has %d, %v", k, got, gotOK, want, wantOK)
+		}
+	}
+
+	// Events that do not fit in the buffer are dropped and reported.
+	feed = NewReplicationFeed[int, int](1)
+	primary = NewManual[int, int](3, WithEventListener[int, int](feed))
+	replica = NewReplica(NewManual[int, int](3).Cache())
+	primary.Set(1, 1)
+	primary.Set(2, 2)
+	err := replica.Apply(<-feed.Events())
+	if err != nil {
+		t.Fatal(err)
+	}
+	primary.Set(3, 3)
+	err = replica.Apply(<-feed.Events())
+	gap := &ReplicationGapError{}
+	if !errors.As(err, &gap) || gap.Expected != 2 || gap.Got != 3 {
+		t.Fatalf("expected a gap from 2 to 3, got %v", err)
+	}
+	replica.Resync(10)
+	err = replica.Apply(ReplicationEvent[int, int]{Seq: 4, Op: ReplicaSet, Key: 4, Value: 4})
+	if err != nil {
+		t.Fatalf("expected old events to be ignored, got %v", err)
 	}
 }
 
-func checkList(t *testing.T, name string, l *list.List[string], expected []byte) {
+func checkList(t *testing.T, name string, l []string, expected []byte) {
+
+	if len(l) != len(expected) {
+		t.Fatalf("list %s has bad length: got=%d want=%d\n", name, len(l), len(expected))
+	}
 
 	idx := 0
 
-	for e := l.Front(); e != nil; e = e.Next() {
-		b := []byte(e.Value)
+	for _, k := range l {
+		b := []byte(k)
 		if b[0] != expected[idx] {
 			t.Errorf("list %s failed idx %d: got=%d want=%d\n", name, idx, b[0], expected[idx])
 		}
@@ -97,6 +3382,34 @@ func checkList(t *testing.T, name string, l *list.List[string], expected []byte)
 	}
 }
 
+func BenchmarkFill(b *testing.B) {
+	for _, prealloc := range []bool{false, true} {
+		name := "default"
+		opts := []Option[int, int]{}
+		if prealloc {
+			name = "preallocated"
+			opts = append(opts, WithPreallocatedEntries[int, int]())
+		}
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i += 1 {
+				cache := NewManual[int, int](100000, opts...)
+				for k := 0; k < 100000; k += 1 {
+					cache.Set(k, k)
+				}
+				// Promote half the keys and churn the rest, filling the
+				// ghost lists too.
+				for k := 0; k < 100000; k += 2 {
+					cache.Get(k)
+				}
+				for k := 100000; k < 150000; k += 1 {
+					cache.Set(k, k)
+				}
+			}
+		})
+	}
+}
+
 func BenchmarkEviction(b *testing.B) {
 
 	cacheCallbacks := Callbacks[int, int]{
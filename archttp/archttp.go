@@ -0,0 +1,82 @@
+// Package archttp serves debug information about a live arc cache over HTTP.
+package archttp
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+
+	arc "github.com/andrewchambers/arc-go"
+)
+
+// Cache is the subset of *arc.Cache used by Handler, so caches with any
+// key and value types can be served.
+type Cache interface {
+	Stats() arc.Stats
+	RecentHitRatio() float64
+	T1Len() int
+	T2Len() int
+	B1Len() int
+	B2Len() int
+	Partition() int
+	DebugJSON() ([]byte, error)
+}
+
+// Handler is an http.Handler reporting cache stats as JSON.
+type Handler struct {
+	Cache Cache
+	// Locker, if set, is held while reading the cache. Caches are not
+	// threadsafe, so this should be the lock guarding all use of Cache.
+	Locker sync.Locker
+	// ShowKeys includes the keys in each list, which may be sensitive.
+	ShowKeys bool
+}
+
+type report struct {
+	Stats          arc.Stats       `json:"stats"`
+	HitRatio       float64         `json:"hit_ratio"`
+	RecentHitRatio float64         `json:"recent_hit_ratio"`
+	T1Len          int             `json:"t1_len"`
+	T2Len          int             `json:"t2_len"`
+	B1Len          int             `json:"b1_len"`
+	B2Len          int             `json:"b2_len"`
+	Partition      int             `json:"partition"`
+	State          json.RawMessage `json:"state,omitempty"`
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rep, err := h.report()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rep)
+}
+
+func (h *Handler) report() (report, error) {
+	if h.Locker != nil {
+		h.Locker.Lock()
+		defer h.Locker.Unlock()
+	}
+	c := h.Cache
+	stats := c.Stats()
+	rep := report{
+		Stats:          stats,
+		HitRatio:       stats.HitRatio(),
+		RecentHitRatio: c.RecentHitRatio(),
+		T1Len:          c.T1Len(),
+		T2Len:          c.T2Len(),
+		B1Len:          c.B1Len(),
+		B2Len:          c.B2Len(),
+		Partition:      c.Partition(),
+	}
+	if h.ShowKeys {
+		state, err := c.DebugJSON()
+		if err != nil {
+			return rep, err
+		}
+		rep.State = state
+	}
+	return rep, nil
+}
@@ -0,0 +1,147 @@
+package archttp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	arc "github.com/andrewchambers/arc-go"
+)
+
+func TestHandler(t *testing.T) {
+
+	cache := arc.New[string, int](10, arc.Callbacks[string, int]{
+		GetValue: func(k string) (int, error) { return len(k), nil },
+	})
+	cache.Get("secret")
+	cache.Get("secret")
+
+	h := &Handler{Cache: cache, Locker: &sync.Mutex{}}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if strings.Contains(rec.Body.String(), "secret") {
+		t.Fatal("keys shown without ShowKeys")
+	}
+	var rep report
+	err := json.Unmarshal(rec.Body.Bytes(), &rep)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep.Stats.Hits != 1 || rep.Stats.Misses != 1 || rep.T2Len != 1 || rep.HitRatio != 0.5 {
+		t.Fatalf("bad report: %+v", rep)
+	}
+
+	h.ShowKeys = true
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if !strings.Contains(rec.Body.String(), "secret") {
+		t.Fatal("expected keys with ShowKeys")
+	}
+}
+
+func TestWarmTransfer(t *testing.T) {
+
+	cache := arc.New[string, int](10, arc.Callbacks[string, int]{
+		GetValue: func(k string) (int, error) { return len(k), nil },
+	})
+	for _, k := range []string{"a", "bb", "ccc", "dddd"} {
+		cache.Get(k)
+	}
+	cache.Get("a")
+	cache.Get("ccc")
+	srv := httptest.NewServer(&WarmHandler[string, int]{Cache: cache, Locker: &sync.Mutex{}})
+	defer srv.Close()
+
+	progress := 0
+	client := &WarmClient[string, int]{
+		URL:        srv.URL,
+		Values:     true,
+		OnProgress: func(done, total int) { progress = done * 100 / total },
+	}
+	errStop := errors.New("stop")
+	got := []string{}
+	warmed := arc.NewManual[string, int](10)
+	replay := func(rec WarmRecord[string, int]) error {
+		got = append(got, rec.Key)
+		warmed.Set(rec.Key, rec.Value)
+		if rec.Frequent {
+			warmed.Set(rec.Key, rec.Value)
+		}
+		return nil
+	}
+	n, err := client.Fetch(context.Background(), 0, func(rec WarmRecord[string, int]) error {
+		if len(got) == 2 {
+			return errStop
+		}
+		if !rec.HasValue || rec.Value != len(rec.Key) {
+			t.Fatalf("bad record: %+v", rec)
+		}
+		return replay(rec)
+	})
+	if !errors.Is(err, errStop) || n != 2 {
+		t.Fatalf("expected interrupted transfer, n=%d err=%v", n, err)
+	}
+
+	n, err = client.Fetch(context.Background(), n, replay)
+	if err != nil || n != 4 || progress != 100 {
+		t.Fatalf("bad resumed transfer, n=%d progress=%d err=%v", n, progress, err)
+	}
+	if strings.Join(got, ",") != "bb,dddd,a,ccc" {
+		t.Fatalf("bad transfer order: %v", got)
+	}
+	// Replaying the records rebuilds the recency order.
+	want, have := cache.Inspect(), warmed.Cache().Inspect()
+	if fmt.Sprint(want.T1, want.T2) != fmt.Sprint(have.T1, have.T2) {
+		t.Fatalf("bad warmed order: got %v %v, want %v %v", have.T1, have.T2, want.T1, want.T2)
+	}
+}
+
+func TestRegistry(t *testing.T) {
+
+	r := &Registry{}
+	for _, name := range []string{"users", "sessions"} {
+		cache := arc.New[string, int](10, arc.Callbacks[string, int]{
+			GetValue: func(k string) (int, error) { return len(k), nil },
+		})
+		cache.Get(name)
+		err := r.Register(name, cache, &sync.Mutex{})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := r.Register("users", nil, nil); err == nil {
+		t.Fatal("expected duplicate name to fail")
+	}
+	if names := r.Names(); strings.Join(names, ",") != "sessions,users" {
+		t.Fatalf("bad names: %v", names)
+	}
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	var reports map[string]report
+	err := json.Unmarshal(rec.Body.Bytes(), &reports)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reports) != 2 || reports["users"].Stats.Misses != 1 {
+		t.Fatalf("bad reports: %+v", reports)
+	}
+
+	r.Unregister("users")
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest("GET", "/users", nil))
+	if rec.Code != 404 {
+		t.Fatalf("expected unregistered cache to be gone, got %d", rec.Code)
+	}
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest("GET", "/sessions", nil))
+	if rec.Code != 200 || !strings.Contains(rec.Body.String(), "t1_len") {
+		t.Fatalf("bad single cache report: %d %s", rec.Code, rec.Body.String())
+	}
+}
@@ -0,0 +1,109 @@
+package archttp
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Registry holds caches by name, so tooling can find every cache in a
+// process without manual wiring. It is safe for concurrent use.
+type Registry struct {
+	// ShowKeys includes the keys in each list, which may be sensitive.
+	ShowKeys bool
+
+	mu      sync.Mutex
+	entries map[string]registered
+}
+
+type registered struct {
+	cache  Cache
+	locker sync.Locker
+}
+
+// DefaultRegistry is a process wide Registry for applications that do
+// not need their own.
+var DefaultRegistry = &Registry{}
+
+// Register adds cache under name, locker guards all use of the cache as
+// for Handler. It fails if the name is already taken.
+func (r *Registry) Register(name string, cache Cache, locker sync.Locker) error {
+	if name == "" || strings.Contains(name, "/") {
+		return fmt.Errorf("invalid cache name %q", name)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.entries[name]; ok {
+		return fmt.Errorf("cache %q is already registered", name)
+	}
+	if r.entries == nil {
+		r.entries = make(map[string]registered)
+	}
+	r.entries[name] = registered{cache: cache, locker: locker}
+	return nil
+}
+
+// Unregister removes the cache registered under name, if any.
+func (r *Registry) Unregister(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.entries, name)
+}
+
+// Names returns the names of the registered caches in sorted order.
+func (r *Registry) Names() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	names := make([]string, 0, len(r.entries))
+	for name := range r.entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Handler returns a Handler for the cache registered under name.
+func (r *Registry) Handler(name string) (*Handler, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	e, ok := r.entries[name]
+	if !ok {
+		return nil, false
+	}
+	return &Handler{Cache: e.cache, Locker: e.locker, ShowKeys: r.ShowKeys}, true
+}
+
+// ServeHTTP reports every registered cache by name at the root path, and
+// a single cache at its name, as Handler does. Mount it with
+// http.StripPrefix when serving it below the root.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	name := strings.Trim(req.URL.Path, "/")
+	if name != "" {
+		h, ok := r.Handler(name)
+		if !ok {
+			http.NotFound(w, req)
+			return
+		}
+		h.ServeHTTP(w, req)
+		return
+	}
+
+	reports := make(map[string]report)
+	for _, name := range r.Names() {
+		h, ok := r.Handler(name)
+		if !ok {
+			continue
+		}
+		rep, err := h.report()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		reports[name] = rep
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(reports)
+}
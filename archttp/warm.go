@@ -0,0 +1,189 @@
+package archttp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+
+	arc "github.com/andrewchambers/arc-go"
+)
+
+// totalHeader reports how many records a warm transfer holds in total.
+const totalHeader = "X-Arc-Warm-Total"
+
+// WarmRecord is a cached key, and its value if values were requested.
+type WarmRecord[K comparable, V any] struct {
+	Key      K
+	Value    V
+	HasValue bool
+	// Frequent is set for keys used more than once, which a receiver
+	// should set twice so they are promoted as in the source cache.
+	Frequent bool
+}
+
+type warmRecord[K comparable, V any] struct {
+	Key      K    `json:"key"`
+	Value    *V   `json:"value,omitempty"`
+	Frequent bool `json:"frequent,omitempty"`
+}
+
+// WarmHandler serves the entries of a cache as newline delimited JSON, so
+// a newly started instance can be warmed from a running one. Records are
+// sent least recently used first, recently used entries before frequently
+// used ones, so setting each record in order, and frequent records twice,
+// rebuilds the recency order of the source. A receiver with less room
+// then keeps the most valuable entries. The query parameter values=1 includes values, which must
+// then be JSON encodable, and from=n skips the first n records to resume
+// an interrupted transfer. The cache is copied when the request starts,
+// so records are positions in that copy and a resumed transfer may see
+// a slightly different order.
+type WarmHandler[K comparable, V any] struct {
+	Cache *arc.Cache[K, V]
+	// Locker, if set, is held while copying the cache. Caches are not
+	// threadsafe, so this should be the lock guarding all use of Cache.
+	Locker sync.Locker
+}
+
+func (h *WarmHandler[K, V]) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	from := 0
+	if s := query.Get("from"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			http.Error(w, "bad from parameter", http.StatusBadRequest)
+			return
+		}
+		from = n
+	}
+	withValues := query.Get("values") == "1"
+
+	records := h.records(withValues)
+	if from > len(records) {
+		from = len(records)
+	}
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set(totalHeader, strconv.Itoa(len(records)))
+	enc := json.NewEncoder(w)
+	for _, rec := range records[from:] {
+		err := enc.Encode(rec)
+		if err != nil {
+			// The client has gone away, it can resume later.
+			return
+		}
+	}
+}
+
+func (h *WarmHandler[K, V]) records(withValues bool) []warmRecord[K, V] {
+	if h.Locker != nil {
+		h.Locker.Lock()
+		defer h.Locker.Unlock()
+	}
+	// Range skips stale entries, Inspect gives the lists in order.
+	values := map[K]V{}
+	h.Cache.Range(func(key K, value V) bool {
+		values[key] = value
+		return true
+	})
+	state := h.Cache.Inspect()
+	records := []warmRecord[K, V]{}
+	for _, l := range [...]struct {
+		keys     []K
+		frequent bool
+	}{{state.T1, false}, {state.T2, true}} {
+		for i := len(l.keys) - 1; i >= 0; i -= 1 {
+			key := l.keys[i]
+			value, ok := values[key]
+			if !ok {
+				continue
+			}
+			rec := warmRecord[K, V]{Key: key, Frequent: l.frequent}
+			if withValues {
+				rec.Value = &value
+			}
+			records = append(records, rec)
+		}
+	}
+	return records
+}
+
+// WarmClient fetches entries from a WarmHandler.
+type WarmClient[K comparable, V any] struct {
+	// Client is used for requests, http.DefaultClient if nil.
+	Client *http.Client
+	// URL is the address of the WarmHandler.
+	URL string
+	// Values requests values as well as keys.
+	Values bool
+	// OnProgress, if set, is called after each record with the number of
+	// records received so far, counting from zero, and the total.
+	OnProgress func(done, total int)
+}
+
+// Fetch calls fn for each record starting at record from. It returns the
+// position after the last record passed to fn, so an interrupted transfer
+// can be resumed by calling Fetch again from there. A typical fn passes
+// values to Cache.Set, twice for frequent records, or collects keys for
+// Cache.Warm.
+func (c *WarmClient[K, V]) Fetch(ctx context.Context, from int, fn func(WarmRecord[K, V]) error) (int, error) {
+	u, err := url.Parse(c.URL)
+	if err != nil {
+		return from, err
+	}
+	query := u.Query()
+	query.Set("from", strconv.Itoa(from))
+	if c.Values {
+		query.Set("values", "1")
+	}
+	u.RawQuery = query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
+	if err != nil {
+		return from, err
+	}
+	client := c.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return from, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return from, fmt.Errorf("warm transfer failed: %s", resp.Status)
+	}
+	total, _ := strconv.Atoi(resp.Header.Get(totalHeader))
+
+	done := from
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var rec warmRecord[K, V]
+		err := dec.Decode(&rec)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return done, err
+		}
+		out := WarmRecord[K, V]{Key: rec.Key, Frequent: rec.Frequent}
+		if rec.Value != nil {
+			out.Value = *rec.Value
+			out.HasValue = true
+		}
+		err = fn(out)
+		if err != nil {
+			return done, err
+		}
+		done += 1
+		if c.OnProgress != nil {
+			c.OnProgress(done, total)
+		}
+	}
+	return done, nil
+}
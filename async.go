@@ -0,0 +1,44 @@
+package arc
+
+import (
+	"sync"
+)
+
+// Result is the outcome of a lookup made by GetAsync.
+type Result[V any] struct {
+	Value V
+	Err   error
+}
+
+// GetAsync looks up key as Get would from a new goroutine, delivering the
+// result on the returned channel, so a caller can start several lookups
+// before waiting on any of them. The channel is buffered, so the result
+// may be ignored.
+//
+// locker guards all use of the cache, it must be the lock the owner of
+// the cache holds, and must not be held by the caller while waiting for
+// the result. It is released while GetValue runs, so GetValue must be safe
+// for concurrent use with the cache owner. Concurrent lookups of the same
+// key are not merged, each may call GetValue. If the key is cached while
+// GetValue runs, the cached value is newer, so it is kept and delivered
+// instead.
+func (c *Cache[K, V]) GetAsync(key K, locker sync.Locker) <-chan Result[V] {
+	if locker == nil {
+		panic("expected a GetAsync Locker")
+	}
+	ch := make(chan Result[V], 1)
+	go func() {
+		locker.Lock()
+		defer locker.Unlock()
+		// Wait for a load slot without the lock, so loads finishing can
+		// take it.
+		getValue := c.guardLoader(c.Callbacks.GetValue)
+		value, err := c.get(key, func(key K) (V, error) {
+			locker.Unlock()
+			defer locker.Lock()
+			return getValue(key)
+		}, GetOptions{})
+		ch <- Result[V]{Value: value, Err: err}
+	}()
+	return ch
+}
@@ -0,0 +1,184 @@
+package arc
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// Audit checks up to n resident entries against the origin using the
+// Verify callback, and deletes any that have diverged as if by Delete, so
+// they are reloaded when next used. It returns the number of entries
+// deleted. It fails with ErrNoVerify if the Verify callback is not set.
+//
+// Dirty entries are expected to differ from the origin and pinned entries
+// cannot be deleted, so neither is checked. Audit keeps the cache busy
+// while Verify runs, see StartAudit for a background sweep that does not.
+func (c *Cache[K, V]) Audit(n int) (int, error) {
+	if c.Callbacks.Verify == nil {
+		return 0, ErrNoVerify
+	}
+	c.beginOp()
+	err := c.applyInvalidations()
+	if err != nil {
+		return 0, err
+	}
+	repaired := 0
+	for _, e := range c.auditCandidates(n) {
+		diverged, err := c.verify(e.key, e.value)
+		if err != nil {
+			return repaired, err
+		}
+		if !diverged {
+			continue
+		}
+		// An earlier deletion may have cascaded to this key.
+		removed, err := c.remove(e.key)
+		if err != nil {
+			return repaired, err
+		}
+		if removed {
+			repaired += 1
+		}
+	}
+	return repaired, nil
+}
+
+// auditCandidates returns up to n entries that an audit may check. Map
+// iteration order is random, so repeated audits cover the cache.
+func (c *Cache[K, V]) auditCandidates(n int) []*entry[K, V] {
+	candidates := []*entry[K, V]{}
+	for _, e := range c.data {
+		if len(candidates) >= n {
+			break
+		}
+		if c.stale(e) || e.dirty || e.pins > 0 {
+			continue
+		}
+		candidates = append(candidates, e)
+	}
+	return candidates
+}
+
+// verify calls Verify, reporting whether value has diverged.
+func (c *Cache[K, V]) verify(key K, value V) (bool, error) {
+	ok, err := c.Callbacks.Verify(key, value)
+	if errors.Is(err, ErrNotFound) || errors.Is(err, ErrSkipCache) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return !ok, nil
+}
+
+// AuditConfig configures StartAudit.
+type AuditConfig[K comparable] struct {
+	// Interval is the time between the start of each audit.
+	Interval time.Duration
+	// Batch is the number of entries checked by each audit, keep it small
+	// so the origin sees a low rate of checks.
+	Batch int
+	// Locker guards all use of the cache, it must be the lock the owner
+	// of the cache holds. It is held to pick entries and to delete those
+	// that diverged, never while Verify runs.
+	Locker sync.Locker
+	// OnDiverged, if set, is called without the lock held for each entry
+	// deleted because it diverged from the origin.
+	OnDiverged func(K)
+	// OnError, if set, is called without the lock held when Verify or a
+	// deletion fails. The entry is left in place.
+	OnError func(K, error)
+}
+
+// StartAudit starts a goroutine that audits a batch of random resident
+// entries each interval as Audit does, for caches where silent staleness
+// is costly. Verify must be safe for concurrent use with the cache owner.
+// A value replaced while it is being verified may be deleted, it is then
+// reloaded when next used.
+//
+// The returned function stops the audit and waits for it to exit.
+func (c *Cache[K, V]) StartAudit(cfg AuditConfig[K]) (stop func()) {
+	if c.Callbacks.Verify == nil {
+		panic("expected a Verify callback")
+	}
+	if cfg.Interval <= 0 {
+		panic("audit interval must be positive")
+	}
+	if cfg.Batch <= 0 {
+		panic("audit batch must be positive")
+	}
+	if cfg.Locker == nil {
+		panic("expected an audit Locker")
+	}
+	done := make(chan struct{})
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(cfg.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				c.auditBatch(cfg, done)
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		wg.Wait()
+	}
+}
+
+func (c *Cache[K, V]) auditBatch(cfg AuditConfig[K], done chan struct{}) {
+	type candidate struct {
+		e     *entry[K, V]
+		key   K
+		value V
+	}
+	cfg.Locker.Lock()
+	batch := []candidate{}
+	// Entries that fail to invalidate are checked like any other.
+	_ = c.applyInvalidations()
+	for _, e := range c.auditCandidates(cfg.Batch) {
+		batch = append(batch, candidate{e: e, key: e.key, value: e.value})
+	}
+	cfg.Locker.Unlock()
+
+	for _, x := range batch {
+		select {
+		case <-done:
+			return
+		default:
+		}
+		diverged, err := c.verify(x.key, x.value)
+		if err != nil {
+			if cfg.OnError != nil {
+				cfg.OnError(x.key, err)
+			}
+			continue
+		}
+		if !diverged {
+			continue
+		}
+		cfg.Locker.Lock()
+		removed := false
+		if e, ok := c.data[x.key]; ok && e == x.e && !e.dirty && e.pins == 0 {
+			c.beginOp()
+			removed, err = c.remove(x.key)
+		}
+		cfg.Locker.Unlock()
+		if err != nil {
+			if cfg.OnError != nil {
+				cfg.OnError(x.key, err)
+			}
+			continue
+		}
+		if removed && cfg.OnDiverged != nil {
+			cfg.OnDiverged(x.key)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package bench
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/andrewchambers/arc-go"
+	"github.com/dgraph-io/ristretto/v2"
+	hcarc "github.com/hashicorp/golang-lru/arc/v2"
+	lru "github.com/hashicorp/golang-lru/v2"
+)
+
+const (
+	cacheSize = 1000
+	keySpace  = 100000
+	traceLen  = 1 << 20
+)
+
+// cache is the subset of behaviour exercised by every workload, each
+// miss is followed by a Set as a read-through cache would do.
+type cache interface {
+	Get(key uint64) bool
+	Set(key uint64)
+}
+
+type arcCache struct {
+	c *arc.Manual[uint64, uint64]
+}
+
+func (c arcCache) Get(key uint64) bool { _, ok := c.c.Get(key); return ok }
+func (c arcCache) Set(key uint64)      { c.c.Set(key, key) }
+
+type lruCache struct {
+	c *lru.Cache[uint64, uint64]
+}
+
+func (c lruCache) Get(key uint64) bool { _, ok := c.c.Get(key); return ok }
+func (c lruCache) Set(key uint64)      { c.c.Add(key, key) }
+
+type hcarcCache struct {
+	c *hcarc.ARCCache[uint64, uint64]
+}
+
+func (c hcarcCache) Get(key uint64) bool { _, ok := c.c.Get(key); return ok }
+func (c hcarcCache) Set(key uint64)      { c.c.Add(key, key) }
+
+type ristrettoCache struct {
+	c *ristretto.Cache[uint64, uint64]
+}
+
+func (c ristrettoCache) Get(key uint64) bool { _, ok := c.c.Get(key); return ok }
+func (c ristrettoCache) Set(key uint64)      { c.c.Set(key, key, 1) }
+
+var caches = []struct {
+	name string
+	new  func() cache
+}{
+	{"arc-go", func() cache {
+		return arcCache{arc.NewManual[uint64, uint64](cacheSize)}
+	}},
+	{"golang-lru", func() cache {
+		c, err := lru.New[uint64, uint64](cacheSize)
+		if err != nil {
+			panic(err)
+		}
+		return lruCache{c}
+	}},
+	{"golang-lru-arc", func() cache {
+		c, err := hcarc.NewARC[uint64, uint64](cacheSize)
+		if err != nil {
+			panic(err)
+		}
+		return hcarcCache{c}
+	}},
+	{"ristretto", func() cache {
+		c, err := ristretto.NewCache(&ristretto.Config[uint64, uint64]{
+			NumCounters: cacheSize * 10,
+			MaxCost:     cacheSize,
+			BufferItems: 64,
+		})
+		if err != nil {
+			panic(err)
+		}
+		return ristrettoCache{c}
+	}},
+}
+
+// zipfTrace is a skewed workload, a few keys are very popular.
+func zipfTrace() []uint64 {
+	r := rand.New(rand.NewSource(1))
+	z := rand.NewZipf(r, 1.01, 1, keySpace-1)
+	trace := make([]uint64, traceLen)
+	for i := range trace {
+		trace[i] = z.Uint64()
+	}
+	return trace
+}
+
+// scanTrace mixes a small hot set with long sequential scans, which
+// flush a plain LRU but should not displace the hot set in ARC.
+func scanTrace() []uint64 {
+	r := rand.New(rand.NewSource(1))
+	trace := make([]uint64, traceLen)
+	next := uint64(cacheSize)
+	for i := range trace {
+		if r.Intn(2) == 0 {
+			trace[i] = uint64(r.Intn(cacheSize / 2))
+		} else {
+			trace[i] = next
+			next += 1
+		}
+	}
+	return trace
+}
+
+func BenchmarkCaches(b *testing.B) {
+	workloads := []struct {
+		name  string
+		trace []uint64
+	}{
+		{"zipf", zipfTrace()},
+		{"scan", scanTrace()},
+	}
+	for _, w := range workloads {
+		for _, c := range caches {
+			b.Run(w.name+"/"+c.name, func(b *testing.B) {
+				cache := c.new()
+				hits := 0
+				b.ResetTimer()
+				for i := 0; i < b.N; i += 1 {
+					key := w.trace[i%len(w.trace)]
+					if cache.Get(key) {
+						hits += 1
+					} else {
+						cache.Set(key)
+					}
+				}
+				b.ReportMetric(100*float64(hits)/float64(b.N), "hit%")
+			})
+		}
+	}
+}
@@ -0,0 +1,18 @@
+module github.com/andrewchambers/arc-go/bench
+
+go 1.23.0
+
+require (
+	github.com/andrewchambers/arc-go v0.0.0
+	github.com/dgraph-io/ristretto/v2 v2.3.0
+	github.com/hashicorp/golang-lru/arc/v2 v2.0.7
+	github.com/hashicorp/golang-lru/v2 v2.0.7
+)
+
+require (
+	github.com/cespare/xxhash/v2 v2.3.0 // indirect
+	github.com/dustin/go-humanize v1.0.1 // indirect
+	golang.org/x/sys v0.35.0 // indirect
+)
+
+replace github.com/andrewchambers/arc-go => ../
@@ -0,0 +1,115 @@
+package arc
+
+import (
+	"fmt"
+	"math"
+)
+
+// Capacity returns the maximum number of entries held by the cache.
+func (c *Cache[K, V]) Capacity() int {
+	return c.cap
+}
+
+// Resize changes the maximum number of entries held by the cache,
+// evicting entries as needed when shrinking. If an eviction fails the
+// error is returned and the capacity is unchanged, though entries evicted
+// before the failure stay evicted.
+func (c *Cache[K, V]) Resize(size int) error {
+	if size < 1 {
+		return fmt.Errorf("cache size must be positive, got %d", size)
+	}
+	c.beginOp()
+	for c.t1.Len()+c.t2.Len() > size {
+		_, _, err := c.evict(c.t1.Len() > min(c.part, size))
+		if err != nil {
+			return err
+		}
+	}
+	c.cap = size
+	c.hintMu.Lock()
+	c.hintCap = size
+	c.hintMu.Unlock()
+	c.part = min(c.part, size)
+	c.ghostCap = max(int(math.Round(c.ghostRatio*float64(size))), 1)
+	c.trimGhosts()
+	return nil
+}
+
+// trimGhosts drops the oldest ghosts until the lists are within bounds.
+func (c *Cache[K, V]) trimGhosts() {
+	for c.b1.Len() > 0 && c.t1.Len()+c.b1.Len() > max(c.cap, c.ghostCap) {
+		c.b1.RemoveOldest()
+	}
+	for c.t1.Len()+c.t2.Len()+c.b1.Len()+c.b2.Len() > c.cap+c.ghostCap {
+		if c.b2.Len() > 0 {
+			c.b2.RemoveOldest()
+		} else {
+			c.b1.RemoveOldest()
+		}
+	}
+}
+
+// CapacityGoal describes the hit ratio and memory use AdjustCapacity aims
+// for.
+type CapacityGoal struct {
+	// HitRatio is the lowest acceptable RecentHitRatio.
+	HitRatio float64
+	// Tolerance is how far RecentHitRatio may rise above HitRatio before
+	// capacity is given back.
+	Tolerance float64
+	// Min and Max bound the capacity.
+	Min int
+	Max int
+	// Step is the fraction of the capacity added or removed at a time,
+	// 0.1 if zero.
+	Step float64
+	// MaxBytes, if positive, bounds EstimatedBytes, which counts memory
+	// held by keys and values only with WithSizer.
+	MaxBytes int64
+}
+
+// AdjustCapacity grows the cache by one step if the recent hit ratio is
+// below the goal, or shrinks it by one step if it is comfortably above,
+// returning the new capacity. With MaxBytes it also shrinks the cache
+// while EstimatedBytes is over budget, and does not grow it past the
+// budget at the current average entry size. Call it periodically, leaving
+// enough lookups between calls for RecentHitRatio to reflect the last
+// change. Resizes are logged by WithLogger.
+func (c *Cache[K, V]) AdjustCapacity(goal CapacityGoal) (int, error) {
+	if goal.Min < 1 || goal.Max < goal.Min {
+		return c.cap, fmt.Errorf("bad capacity bounds %d to %d", goal.Min, goal.Max)
+	}
+	if goal.MaxBytes < 0 {
+		return c.cap, fmt.Errorf("bad byte budget %d", goal.MaxBytes)
+	}
+	step := goal.Step
+	if step == 0 {
+		step = 0.1
+	}
+	delta := max(int(float64(c.cap)*step), 1)
+	size := c.cap
+	ratio := c.RecentHitRatio()
+	if ratio < goal.HitRatio {
+		size += delta
+	} else if ratio > goal.HitRatio+goal.Tolerance {
+		size -= delta
+	}
+	var bytes int64
+	if goal.MaxBytes > 0 {
+		bytes = c.EstimatedBytes()
+		n := int64(len(c.data))
+		if bytes > goal.MaxBytes {
+			size = c.cap - delta
+		} else if size > c.cap && n > 0 && bytes/n*int64(size) > goal.MaxBytes {
+			size = c.cap
+		}
+	}
+	size = min(max(size, goal.Min), goal.Max)
+	if size == c.cap {
+		return size, nil
+	}
+	old := c.cap
+	err := c.Resize(size)
+	c.logResize(old, c.cap, ratio, bytes, err)
+	return c.cap, err
+}
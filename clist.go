@@ -1,22 +1,42 @@
 package arc
 
 import (
-	"fmt"
-	"strings"
-
-	"github.com/andrewchambers/list-go"
+	"sync"
 )
 
+// cnode is an element of a clist.
+type cnode[K comparable] struct {
+	key        K
+	prev, next *cnode[K]
+}
+
+// clist is a list of keys ordered from most to least recently added,
+// indexed by key. It is circular through root, so root.next is the front
+// and root.prev the back.
 type clist[K comparable] struct {
-	l    *list.List[K]
-	keys map[K]*list.Element[K]
+	root cnode[K]
+	len  int
+	keys map[K]*cnode[K]
+	// free recycles removed nodes.
+	free sync.Pool
+	// slab holds preallocated nodes not yet used.
+	slab []cnode[K]
+	// gen is incremented by every structural modification.
+	gen uint64
 }
 
-func newClist[K comparable]() *clist[K] {
-	return &clist[K]{
-		l:    list.New[K](),
-		keys: make(map[K]*list.Element[K]),
+func newClist[K comparable](hint int) *clist[K] {
+	c := &clist[K]{
+		keys: make(map[K]*cnode[K], hint),
 	}
+	c.root.next = &c.root
+	c.root.prev = &c.root
+	return c
+}
+
+// preallocate allocates n nodes up front for later pushes.
+func (c *clist[K]) preallocate(n int) {
+	c.slab = make([]cnode[K], n)
 }
 
 func (c *clist[K]) Has(key K) bool {
@@ -24,58 +44,77 @@ func (c *clist[K]) Has(key K) bool {
 	return ok
 }
 
-func (c *clist[K]) Lookup(key K) *list.Element[K] {
-	elt := c.keys[key]
-	return elt
+func (c *clist[K]) Lookup(key K) *cnode[K] {
+	return c.keys[key]
 }
 
-func (c *clist[K]) MoveToFront(elt *list.Element[K]) {
-	c.l.MoveToFront(elt)
+func (c *clist[K]) MoveToFront(n *cnode[K]) {
+	c.gen += 1
+	if c.root.next == n {
+		return
+	}
+	c.unlink(n)
+	c.insertFront(n)
 }
 
 func (c *clist[K]) PushFront(key K) {
-	elt := c.l.PushFront(key)
-	c.keys[key] = elt
+	c.gen += 1
+	n, _ := c.free.Get().(*cnode[K])
+	if n == nil && len(c.slab) != 0 {
+		n = &c.slab[0]
+		c.slab = c.slab[1:]
+	}
+	if n == nil {
+		n = &cnode[K]{}
+	}
+	n.key = key
+	c.insertFront(n)
+	c.keys[key] = n
+	c.len += 1
 }
 
-func (c *clist[K]) Remove(key K, elt *list.Element[K]) {
+func (c *clist[K]) Remove(key K, n *cnode[K]) {
+	c.gen += 1
 	delete(c.keys, key)
-	c.l.Remove(elt)
-}
-
-func (c *clist[K]) PushBack(key K) {
-	elt := c.l.PushBack(key)
-	c.keys[key] = elt
+	c.unlink(n)
+	*n = cnode[K]{}
+	c.free.Put(n)
+	c.len -= 1
 }
 
 func (c *clist[K]) Pop() K {
-	elt := c.l.Back()
-	key := elt.Value
-	c.Remove(key, elt)
+	n := c.root.prev
+	key := n.key
+	c.Remove(key, n)
 	return key
 }
 
-func (c *clist[K]) Last() K {
-	elt := c.l.Back()
-	key := elt.Value
-	return key
+func (c *clist[K]) insertFront(n *cnode[K]) {
+	n.prev = &c.root
+	n.next = c.root.next
+	n.next.prev = n
+	c.root.next = n
 }
 
-func (c *clist[K]) Len() int {
-	return c.l.Len()
+func (c *clist[K]) unlink(n *cnode[K]) {
+	n.prev.next = n.next
+	n.next.prev = n.prev
+}
+
+// Compact rebuilds the key index, releasing space left by removed keys.
+func (c *clist[K]) Compact() {
+	c.keys = compactMap(c.keys)
 }
 
-func (c *clist[K]) DebugDump() string {
-	var sb strings.Builder
+func (c *clist[K]) Len() int {
+	return c.len
+}
 
-	sb.WriteString("{")
-	for e := c.l.Front(); e != nil; e = e.Next() {
-		if e != c.l.Front() {
-			sb.WriteString(", ")
-		}
-		fmt.Fprintf(&sb, "%v", e.Value)
+// Keys returns the keys from front to back.
+func (c *clist[K]) Keys() []K {
+	keys := make([]K, 0, c.len)
+	for n := c.root.next; n != &c.root; n = n.next {
+		keys = append(keys, n.key)
 	}
-	sb.WriteString("}")
-
-	return sb.String()
+	return keys
 }
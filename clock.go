@@ -0,0 +1,25 @@
+package arc
+
+import (
+	"time"
+)
+
+// Clock is the source of time for expiry and retry backoff, see
+// WithClock. The fakeclock package provides one for tests.
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// WithClock makes the cache read the time from clock instead of the
+// system clock, so tests can control expiry without sleeping.
+func WithClock[K comparable, V any](clock Clock) Option[K, V] {
+	return func(c *Cache[K, V]) {
+		c.clock = clock
+	}
+}
@@ -0,0 +1,101 @@
+package arc
+
+import (
+	"bytes"
+	"compress/flate"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Compressor compresses encoded values, see Compressed. s2 or zstd can be
+// used by implementing it, FlateCompressor needs no dependencies.
+type Compressor interface {
+	Compress(data []byte) ([]byte, error)
+	Decompress(data []byte) ([]byte, error)
+}
+
+// Each value encoded by Compressed starts with one of these.
+const (
+	uncompressedValue byte = iota
+	compressedValue
+)
+
+type compressed[V any] struct {
+	codec      Codec[V]
+	compressor Compressor
+}
+
+// Compressed returns a Codec compressing the output of codec, for example
+// to fit more values on disk with DiskL2. Values that do not shrink, such
+// as already compressed images, are stored uncompressed so reading them
+// costs nothing extra.
+func Compressed[V any](codec Codec[V], compressor Compressor) Codec[V] {
+	return compressed[V]{codec: codec, compressor: compressor}
+}
+
+func (c compressed[V]) Encode(value V) ([]byte, error) {
+	data, err := c.codec.Encode(value)
+	if err != nil {
+		return nil, err
+	}
+	packed, err := c.compressor.Compress(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(packed) < len(data) {
+		return append([]byte{compressedValue}, packed...), nil
+	}
+	return append([]byte{uncompressedValue}, data...), nil
+}
+
+func (c compressed[V]) Decode(data []byte) (V, error) {
+	if len(data) == 0 {
+		var zero V
+		return zero, errors.New("empty compressed value")
+	}
+	switch data[0] {
+	case uncompressedValue:
+		return c.codec.Decode(data[1:])
+	case compressedValue:
+		unpacked, err := c.compressor.Decompress(data[1:])
+		if err != nil {
+			var zero V
+			return zero, err
+		}
+		return c.codec.Decode(unpacked)
+	default:
+		var zero V
+		return zero, fmt.Errorf("unknown compressed value header %d", data[0])
+	}
+}
+
+// FlateCompressor compresses with compress/flate at Level, or the default
+// level if zero.
+type FlateCompressor struct {
+	Level int
+}
+
+func (f FlateCompressor) Compress(data []byte) ([]byte, error) {
+	level := f.Level
+	if level == 0 {
+		level = flate.DefaultCompression
+	}
+	var buf bytes.Buffer
+	w, err := flate.NewWriter(&buf, level)
+	if err != nil {
+		return nil, err
+	}
+	_, err = w.Write(data)
+	if err == nil {
+		err = w.Close()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (FlateCompressor) Decompress(data []byte) ([]byte, error) {
+	return io.ReadAll(flate.NewReader(bytes.NewReader(data)))
+}
@@ -0,0 +1,114 @@
+package arc
+
+import (
+	"fmt"
+)
+
+// CorruptionError describes an internal inconsistency found in the cache,
+// with enough context to make a bug report actionable.
+type CorruptionError[K comparable] struct {
+	Reason string
+	State  State[K]
+	// Ops holds the most recent operations, oldest first, when the cache
+	// was created with WithOpHistory.
+	Ops []Op[K]
+}
+
+func (e *CorruptionError[K]) Error() string {
+	return "cache corrupted: " + e.Reason
+}
+
+// Op is an operation recorded by WithOpHistory.
+type Op[K comparable] struct {
+	Name string `json:"name"`
+	Key  K      `json:"key"`
+}
+
+// WithOpHistory records the last n operations, so they can be included
+// in a CorruptionError.
+func WithOpHistory[K comparable, V any](n int) Option[K, V] {
+	return func(c *Cache[K, V]) {
+		c.ops = &opRing[K]{ops: make([]Op[K], 0, max(n, 0))}
+	}
+}
+
+// WithCorruptionHandler calls fn when the cache detects that it is
+// corrupted, the operation then fails with the CorruptionError. Without a
+// handler the cache panics with the CorruptionError instead.
+func WithCorruptionHandler[K comparable, V any](fn func(*CorruptionError[K])) Option[K, V] {
+	return func(c *Cache[K, V]) {
+		c.onCorrupt = fn
+	}
+}
+
+type opRing[K comparable] struct {
+	ops  []Op[K]
+	next int
+}
+
+func (r *opRing[K]) add(op Op[K]) {
+	if len(r.ops) < cap(r.ops) {
+		r.ops = append(r.ops, op)
+		return
+	}
+	if len(r.ops) == 0 {
+		return
+	}
+	r.ops[r.next] = op
+	r.next = (r.next + 1) % len(r.ops)
+}
+
+// list returns the recorded operations, oldest first.
+func (r *opRing[K]) list() []Op[K] {
+	ops := make([]Op[K], 0, len(r.ops))
+	ops = append(ops, r.ops[r.next:]...)
+	return append(ops, r.ops[:r.next]...)
+}
+
+func (c *Cache[K, V]) recordOp(name string, key K) {
+	if c.ops != nil {
+		c.ops.add(Op[K]{Name: name, Key: key})
+	}
+}
+
+func (c *Cache[K, V]) corruption(format string, args ...any) *CorruptionError[K] {
+	err := &CorruptionError[K]{
+		Reason: fmt.Sprintf(format, args...),
+		State:  c.Inspect(),
+	}
+	if c.ops != nil {
+		err.Ops = c.ops.list()
+	}
+	return err
+}
+
+// corrupt reports an inconsistency found during an operation.
+func (c *Cache[K, V]) corrupt(format string, args ...any) error {
+	err := c.corruption(format, args...)
+	if c.onCorrupt == nil {
+		panic(err)
+	}
+	c.onCorrupt(err)
+	return err
+}
+
+// CheckConsistency verifies that the cache lists and values agree,
+// returning a *CorruptionError if they do not. It takes time proportional
+// to the number of entries and is intended for tests and debugging.
+func (c *Cache[K, V]) CheckConsistency() error {
+	if c.t1.Len()+c.t2.Len() > c.cap {
+		return c.corruption("%d resident entries exceed capacity %d", c.t1.Len()+c.t2.Len(), c.cap)
+	}
+	if c.t1.Len()+c.t2.Len() != len(c.data) {
+		return c.corruption("%d listed entries but %d values", c.t1.Len()+c.t2.Len(), len(c.data))
+	}
+	for key, e := range c.data {
+		if e.key != key || (e.list != c.t1 && e.list != c.t2) {
+			return c.corruption("%v must be in exactly one of t1 and t2", key)
+		}
+	}
+	if c.part < 0 || c.part > c.cap {
+		return c.corruption("partition %d out of range", c.part)
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package arc
+
+// AddDependency records that the cached value of child is derived from
+// parent, so deleting, invalidating or expiring parent also removes child,
+// and in turn anything derived from child. Evicting parent does not affect
+// child. The dependency is forgotten when child leaves the cache.
+// AddDependency returns false if child is not cached, parent need not be.
+func (c *Cache[K, V]) AddDependency(child, parent K) bool {
+	if _, ok := c.data[child]; !ok {
+		return false
+	}
+	if c.dependents == nil {
+		c.dependents = make(map[K]map[K]struct{})
+		c.dependencies = make(map[K]map[K]struct{})
+	}
+	addEdge(c.dependents, parent, child)
+	addEdge(c.dependencies, child, parent)
+	return true
+}
+
+func addEdge[K comparable](edges map[K]map[K]struct{}, from, to K) {
+	set, ok := edges[from]
+	if !ok {
+		set = make(map[K]struct{})
+		edges[from] = set
+	}
+	set[to] = struct{}{}
+}
+
+func removeEdge[K comparable](edges map[K]map[K]struct{}, from, to K) {
+	set := edges[from]
+	delete(set, to)
+	if len(set) == 0 {
+		delete(edges, from)
+	}
+}
+
+// forgetDependencies drops the dependencies of a key leaving the cache.
+func (c *Cache[K, V]) forgetDependencies(child K) {
+	for parent := range c.dependencies[child] {
+		removeEdge(c.dependents, parent, child)
+	}
+	delete(c.dependencies, child)
+}
+
+// removeDependents removes the keys derived from parent, returning the
+// first error but still attempting the rest.
+func (c *Cache[K, V]) removeDependents(parent K, expired bool) error {
+	children := c.dependents[parent]
+	if len(children) == 0 {
+		return nil
+	}
+	keys := make([]K, 0, len(children))
+	for child := range children {
+		keys = append(keys, child)
+	}
+	var firstErr error
+	for _, child := range keys {
+		_, err := c.removeEntry(child, expired)
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
@@ -0,0 +1,114 @@
+package arc
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// DiskL2 is an L2 storing each value in its own file in a directory, for
+// spilling large values evicted from memory to local disk with Tiered.
+// Files are only removed by Delete, so the directory grows with the
+// number of distinct keys demoted.
+//
+// Each file is named by a hash of the encoded key and holds the encoded
+// key, as a uvarint length and the bytes, followed by the encoded value.
+// A file holding a different key is treated as a miss.
+type DiskL2[K comparable, V any] struct {
+	dir    string
+	keys   Codec[K]
+	values Codec[V]
+}
+
+// NewDiskL2 returns a DiskL2 storing values in dir, which is created if
+// needed, with keys and values encoded by the given codecs. Equal keys
+// must encode to the same bytes.
+func NewDiskL2[K comparable, V any](dir string, keys Codec[K], values Codec[V]) (*DiskL2[K, V], error) {
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return nil, err
+	}
+	return &DiskL2[K, V]{dir: dir, keys: keys, values: values}, nil
+}
+
+// path returns the file holding the encoded key k.
+func (d *DiskL2[K, V]) path(k []byte) string {
+	sum := sha256.Sum256(k)
+	return filepath.Join(d.dir, hex.EncodeToString(sum[:]))
+}
+
+func (d *DiskL2[K, V]) Get(key K) (V, bool, error) {
+	var zero V
+	k, err := d.keys.Encode(key)
+	if err != nil {
+		return zero, false, err
+	}
+	data, err := os.ReadFile(d.path(k))
+	if errors.Is(err, fs.ErrNotExist) {
+		return zero, false, nil
+	}
+	if err != nil {
+		return zero, false, err
+	}
+	n, size := binary.Uvarint(data)
+	if size <= 0 || n > uint64(len(data)-size) {
+		return zero, false, errors.New("corrupt disk cache file")
+	}
+	data = data[size:]
+	if !bytes.Equal(data[:n], k) {
+		// Another key with the same hash.
+		return zero, false, nil
+	}
+	value, err := d.values.Decode(data[n:])
+	if err != nil {
+		return zero, false, err
+	}
+	return value, true, nil
+}
+
+// Set writes value to a temporary file and renames it into place, so a
+// crash never leaves a partial value.
+func (d *DiskL2[K, V]) Set(key K, value V) error {
+	k, err := d.keys.Encode(key)
+	if err != nil {
+		return err
+	}
+	v, err := d.values.Encode(value)
+	if err != nil {
+		return err
+	}
+	data := binary.AppendUvarint(make([]byte, 0, binary.MaxVarintLen64+len(k)+len(v)), uint64(len(k)))
+	data = append(append(data, k...), v...)
+	f, err := os.CreateTemp(d.dir, "tmp-")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), d.path(k))
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+func (d *DiskL2[K, V]) Delete(key K) error {
+	k, err := d.keys.Encode(key)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(d.path(k))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,119 @@
+package arc
+
+// entry is a resident key, holding its value and its place in t1 or t2,
+// so a single map lookup finds everything about it. Entries are the nodes
+// of their list, so admitting a key allocates only the entry itself.
+type entry[K comparable, V any] struct {
+	key   K
+	value V
+	// list is t1 or t2, whichever holds the entry, or nil once removed.
+	list       *entryList[K, V]
+	prev, next *entry[K, V]
+	// born is the generation the entry was stored in.
+	born uint64
+	// pins counts calls to Pin not yet matched by Unpin.
+	pins int
+	// dirty is set while a value stored by Set is not yet written back.
+	dirty bool
+	// expiry is only set with WithExpiry, and stats with WithEntryStats.
+	expiry *expiryEntry[K]
+	stats  *entryStats
+}
+
+// following returns the entry after e in its list, or nil.
+func (e *entry[K, V]) following() *entry[K, V] {
+	if n := e.next; n != &e.list.root {
+		return n
+	}
+	return nil
+}
+
+// preceding returns the entry before e in its list, or nil.
+func (e *entry[K, V]) preceding() *entry[K, V] {
+	if p := e.prev; p != &e.list.root {
+		return p
+	}
+	return nil
+}
+
+// entryList is an intrusive list of resident entries ordered from most to
+// least recently used. It is circular through root, so root.next is the
+// front and root.prev the back. Unlike clist it needs no key index,
+// entries record their own position.
+type entryList[K comparable, V any] struct {
+	root entry[K, V]
+	len  int
+	// gen is incremented by every structural modification.
+	gen uint64
+}
+
+func newEntryList[K comparable, V any]() *entryList[K, V] {
+	l := &entryList[K, V]{}
+	l.root.next = &l.root
+	l.root.prev = &l.root
+	return l
+}
+
+func (l *entryList[K, V]) PushFront(e *entry[K, V]) {
+	l.gen += 1
+	e.list = l
+	l.insertFront(e)
+	l.len += 1
+}
+
+func (l *entryList[K, V]) MoveToFront(e *entry[K, V]) {
+	l.gen += 1
+	if l.root.next == e {
+		return
+	}
+	l.unlink(e)
+	l.insertFront(e)
+}
+
+func (l *entryList[K, V]) Remove(e *entry[K, V]) {
+	l.gen += 1
+	l.unlink(e)
+	e.list = nil
+	e.prev = nil
+	e.next = nil
+	l.len -= 1
+}
+
+func (l *entryList[K, V]) insertFront(e *entry[K, V]) {
+	e.prev = &l.root
+	e.next = l.root.next
+	e.next.prev = e
+	l.root.next = e
+}
+
+func (l *entryList[K, V]) unlink(e *entry[K, V]) {
+	e.prev.next = e.next
+	e.next.prev = e.prev
+}
+
+func (l *entryList[K, V]) Front() *entry[K, V] {
+	if l.len == 0 {
+		return nil
+	}
+	return l.root.next
+}
+
+func (l *entryList[K, V]) Back() *entry[K, V] {
+	if l.len == 0 {
+		return nil
+	}
+	return l.root.prev
+}
+
+func (l *entryList[K, V]) Len() int {
+	return l.len
+}
+
+// Keys returns the keys from front to back.
+func (l *entryList[K, V]) Keys() []K {
+	keys := make([]K, 0, l.len)
+	for e := l.Front(); e != nil; e = e.following() {
+		keys = append(keys, e.key)
+	}
+	return keys
+}
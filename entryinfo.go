@@ -0,0 +1,100 @@
+package arc
+
+import (
+	"sort"
+	"time"
+)
+
+// Info describes a cached entry, see EntryInfo.
+type Info struct {
+	// List is "T1" for entries seen once recently, or "T2" for entries
+	// seen at least twice.
+	List string
+	// Added, LastAccess and Hits are only tracked with WithEntryStats.
+	Added      time.Time
+	LastAccess time.Time
+	Hits       uint64
+	// Expires is when the entry expires, and TTL the time remaining. Both
+	// are zero if the entry does not expire.
+	Expires time.Time
+	TTL     time.Duration
+}
+
+type entryStats struct {
+	added    time.Time
+	accessed time.Time
+	hits     uint64
+}
+
+// WithEntryStats tracks when each entry was added and last accessed, and
+// how many hits it has had, at the cost of some memory per entry.
+func WithEntryStats[K comparable, V any]() Option[K, V] {
+	return func(c *Cache[K, V]) {
+		c.trackStats = true
+	}
+}
+
+// EntryInfo describes a cached entry, or returns false if key is not
+// cached. It does not count as an access.
+func (c *Cache[K, V]) EntryInfo(key K) (Info, bool) {
+	e, ok := c.data[key]
+	if !ok || c.stale(e) {
+		return Info{}, false
+	}
+	info := Info{List: "T2"}
+	if e.list == c.t1 {
+		info.List = "T1"
+	}
+	if s := e.stats; s != nil {
+		info.Added = s.added
+		info.LastAccess = s.accessed
+		info.Hits = s.hits
+	}
+	if x := e.expiry; x != nil && !x.expires.IsZero() {
+		info.Expires = x.expires
+		info.TTL = x.expires.Sub(c.clock.Now())
+	}
+	return info, true
+}
+
+// KeyStats is the hit count of a cached key, see TopKeys.
+type KeyStats[K comparable] struct {
+	Key  K
+	Hits uint64
+}
+
+// TopKeys returns up to n cached keys with the most hits, most hit first.
+// It requires WithEntryStats and returns nil without it. It takes time
+// proportional to the number of entries.
+func (c *Cache[K, V]) TopKeys(n int) []KeyStats[K] {
+	if !c.trackStats || n <= 0 {
+		return nil
+	}
+	keys := make([]KeyStats[K], 0, len(c.data))
+	for key, e := range c.data {
+		if c.stale(e) {
+			continue
+		}
+		keys = append(keys, KeyStats[K]{Key: key, Hits: e.stats.hits})
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].Hits > keys[j].Hits })
+	if len(keys) > n {
+		keys = keys[:n]
+	}
+	return keys
+}
+
+// added records a newly resident entry.
+func (c *Cache[K, V]) added(e *entry[K, V]) {
+	now := c.clock.Now()
+	e.stats = &entryStats{added: now, accessed: now}
+}
+
+// accessed records a hit on a resident entry.
+func (c *Cache[K, V]) accessed(e *entry[K, V]) {
+	c.expireRead(e)
+	if s := e.stats; s != nil {
+		s.accessed = c.clock.Now()
+		s.hits += 1
+	}
+}
@@ -0,0 +1,52 @@
+package arc
+
+// EventListener is notified synchronously of cache activity, for building
+// custom metrics, tracing or replication. Embed NopListener to implement
+// only the methods of interest.
+type EventListener[K comparable, V any] interface {
+	// OnAdd is called when a key becomes resident.
+	OnAdd(key K, value V)
+	// OnHit is called when a lookup finds a resident key.
+	OnHit(key K, value V)
+	// OnMiss is called when a lookup does not find a resident key.
+	OnMiss(key K)
+	// OnEvict is called when a resident key is evicted or deleted.
+	OnEvict(key K, value V)
+	// OnExpire is called when a resident key is removed because it is stale or expired.
+	OnExpire(key K, value V)
+	// OnUpdate is called when the value of a resident key is replaced.
+	OnUpdate(key K, old, new V)
+}
+
+// NopListener is an EventListener that does nothing.
+type NopListener[K comparable, V any] struct{}
+
+func (NopListener[K, V]) OnAdd(K, V)       {}
+func (NopListener[K, V]) OnHit(K, V)       {}
+func (NopListener[K, V]) OnMiss(K)         {}
+func (NopListener[K, V]) OnEvict(K, V)     {}
+func (NopListener[K, V]) OnExpire(K, V)    {}
+func (NopListener[K, V]) OnUpdate(K, V, V) {}
+
+// OpID returns the ID of the operation in progress, or of the last one.
+// Each Get, Set, Delete or other operation that can emit events is given
+// the next ID, starting from 1, so an EventListener can call OpID to tell
+// which events, such as a miss, an eviction and an add, belong to the
+// same operation.
+func (c *Cache[K, V]) OpID() uint64 {
+	return c.opID
+}
+
+// beginOp assigns the next operation ID, returning it.
+func (c *Cache[K, V]) beginOp() uint64 {
+	c.lastOpID += 1
+	c.opID = c.lastOpID
+	return c.opID
+}
+
+// WithEventListener attaches an EventListener to the cache.
+func WithEventListener[K comparable, V any](l EventListener[K, V]) Option[K, V] {
+	return func(c *Cache[K, V]) {
+		c.listener = l
+	}
+}
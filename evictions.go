@@ -0,0 +1,30 @@
+package arc
+
+// EvictedEntry is an entry that has left the cache, see Evictions.
+type EvictedEntry[K comparable, V any] struct {
+	Key   K
+	Value V
+}
+
+// WithEvictionChannel delivers every entry that leaves the cache, whether
+// evicted, deleted or expired, to a channel with the given buffer size,
+// so slow cleanup can run outside of Get. Sends block when the buffer is
+// full, so the channel must be drained promptly. buffer must not be
+// negative.
+func WithEvictionChannel[K comparable, V any](buffer int) Option[K, V] {
+	return func(c *Cache[K, V]) {
+		c.evictionBuffer = &buffer
+	}
+}
+
+// Evictions returns the channel configured by WithEvictionChannel,
+// or nil if it was not used.
+func (c *Cache[K, V]) Evictions() <-chan EvictedEntry[K, V] {
+	return c.evictions
+}
+
+func (c *Cache[K, V]) sendEviction(key K, value V) {
+	if c.evictions != nil {
+		c.evictions <- EvictedEntry[K, V]{Key: key, Value: value}
+	}
+}
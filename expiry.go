@@ -0,0 +1,322 @@
+package arc
+
+import (
+	"container/heap"
+	"math/rand"
+	"time"
+)
+
+// ExpiryPolicy decides when cached entries expire. Each method returns
+// the time at which the entry expires, or the zero time if it does not.
+// Expired entries are deleted as if by Delete when they are next touched,
+// or evicted normally if they never are.
+//
+// Pins do not outlive expiry: an expired value is never served, pinned or
+// not, except by WithStaleOnTimeout when a reload times out. A pinned entry cannot be deleted though, so once expired it keeps
+// its pins and place in the cache, and its value is replaced in place by
+// the next load or Set of the key.
+type ExpiryPolicy[K comparable, V any] interface {
+	// ExpireAfterCreate is called when key becomes resident.
+	ExpireAfterCreate(key K, value V, now time.Time) time.Time
+	// ExpireAfterUpdate is called when the value of a resident key is
+	// replaced. written is when the previous value was stored and expires
+	// is its current expiry.
+	ExpireAfterUpdate(key K, value V, now, written, expires time.Time) time.Time
+	// ExpireAfterRead is called when a resident key is accessed. written
+	// is when the value was stored and expires is its current expiry.
+	ExpireAfterRead(key K, value V, now, written, expires time.Time) time.Time
+}
+
+// WithExpiry expires entries according to policy.
+func WithExpiry[K comparable, V any](policy ExpiryPolicy[K, V]) Option[K, V] {
+	return func(c *Cache[K, V]) {
+		c.expiry = policy
+	}
+}
+
+// expiryEntry tracks the expiry of a resident key. Entries that expire
+// are also kept in a heap ordered by expiry, so RemoveExpired only visits
+// what has expired.
+type expiryEntry[K comparable] struct {
+	key     K
+	written time.Time
+	expires time.Time
+	// index is the position in the heap, or -1 if the entry never expires.
+	index int
+}
+
+type expiryHeap[K comparable] []*expiryEntry[K]
+
+func (h expiryHeap[K]) Len() int           { return len(h) }
+func (h expiryHeap[K]) Less(i, j int) bool { return h[i].expires.Before(h[j].expires) }
+
+func (h expiryHeap[K]) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *expiryHeap[K]) Push(x any) {
+	e := x.(*expiryEntry[K])
+	e.index = len(*h)
+	*h = append(*h, e)
+}
+
+func (h *expiryHeap[K]) Pop() any {
+	old := *h
+	e := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	e.index = -1
+	return e
+}
+
+// after returns the time d after now, or the zero time if d is not positive.
+func after(now time.Time, d time.Duration) time.Time {
+	if d <= 0 {
+		return time.Time{}
+	}
+	return now.Add(d)
+}
+
+// earliest returns the earlier of two expiry times, where the zero time
+// means never.
+func earliest(a, b time.Time) time.Time {
+	if a.IsZero() || (!b.IsZero() && b.Before(a)) {
+		return b
+	}
+	return a
+}
+
+type ttl[K comparable, V any] time.Duration
+
+// TTL expires entries d after they were last written, reads do not
+// extend their life. A non-positive d means entries do not expire.
+func TTL[K comparable, V any](d time.Duration) ExpiryPolicy[K, V] {
+	return ttl[K, V](d)
+}
+
+func (p ttl[K, V]) ExpireAfterCreate(_ K, _ V, now time.Time) time.Time {
+	return after(now, time.Duration(p))
+}
+
+func (p ttl[K, V]) ExpireAfterUpdate(_ K, _ V, now, _, _ time.Time) time.Time {
+	return after(now, time.Duration(p))
+}
+
+func (p ttl[K, V]) ExpireAfterRead(_ K, _ V, _, _, expires time.Time) time.Time {
+	return expires
+}
+
+type tti[K comparable, V any] time.Duration
+
+// TTI expires entries d after they were last written or read, so entries
+// in use are kept indefinitely. A non-positive d means entries do not
+// expire.
+func TTI[K comparable, V any](d time.Duration) ExpiryPolicy[K, V] {
+	return tti[K, V](d)
+}
+
+func (p tti[K, V]) ExpireAfterCreate(_ K, _ V, now time.Time) time.Time {
+	return after(now, time.Duration(p))
+}
+
+func (p tti[K, V]) ExpireAfterUpdate(_ K, _ V, now, _, _ time.Time) time.Time {
+	return after(now, time.Duration(p))
+}
+
+func (p tti[K, V]) ExpireAfterRead(_ K, _ V, now, _, _ time.Time) time.Time {
+	return after(now, time.Duration(p))
+}
+
+type ttlAndTTI[K comparable, V any] struct {
+	ttl time.Duration
+	tti time.Duration
+}
+
+// TTLAndTTI expires entries once either policy would, so an entry lives
+// at most ttl after it was written, and less if it is idle for tti.
+func TTLAndTTI[K comparable, V any](ttl, tti time.Duration) ExpiryPolicy[K, V] {
+	return ttlAndTTI[K, V]{ttl: ttl, tti: tti}
+}
+
+func (p ttlAndTTI[K, V]) ExpireAfterCreate(_ K, _ V, now time.Time) time.Time {
+	return earliest(after(now, p.ttl), after(now, p.tti))
+}
+
+func (p ttlAndTTI[K, V]) ExpireAfterUpdate(_ K, _ V, now, _, _ time.Time) time.Time {
+	return earliest(after(now, p.ttl), after(now, p.tti))
+}
+
+func (p ttlAndTTI[K, V]) ExpireAfterRead(_ K, _ V, now, written, _ time.Time) time.Time {
+	return earliest(after(written, p.ttl), after(now, p.tti))
+}
+
+// ExpiryFunc is an ExpiryPolicy where the lifetime of each value is
+// decided when it is written, for example by the loader encoding it in the
+// value. A non-positive result means the value does not expire.
+type ExpiryFunc[K comparable, V any] func(key K, value V) time.Duration
+
+func (f ExpiryFunc[K, V]) ExpireAfterCreate(key K, value V, now time.Time) time.Time {
+	return after(now, f(key, value))
+}
+
+func (f ExpiryFunc[K, V]) ExpireAfterUpdate(key K, value V, now, _, _ time.Time) time.Time {
+	return after(now, f(key, value))
+}
+
+func (f ExpiryFunc[K, V]) ExpireAfterRead(_ K, _ V, _, _, expires time.Time) time.Time {
+	return expires
+}
+
+type jitter[K comparable, V any] struct {
+	policy   ExpiryPolicy[K, V]
+	fraction float64
+}
+
+// Jitter shortens each lifetime given by policy by a random amount of up
+// to fraction of it, so entries written together do not all expire, and
+// reload, at the same moment. fraction must be between 0 and 1.
+func Jitter[K comparable, V any](policy ExpiryPolicy[K, V], fraction float64) ExpiryPolicy[K, V] {
+	if fraction < 0 || fraction > 1 {
+		panic("jitter fraction must be between 0 and 1")
+	}
+	return jitter[K, V]{policy: policy, fraction: fraction}
+}
+
+// apply jitters a newly computed expiry, unchanged expiries are kept so
+// repeated reads do not keep shortening them.
+func (p jitter[K, V]) apply(now, expires, previous time.Time) time.Time {
+	if expires.IsZero() || expires.Equal(previous) || !expires.After(now) {
+		return expires
+	}
+	lifetime := float64(expires.Sub(now))
+	return expires.Add(-time.Duration(lifetime * p.fraction * rand.Float64()))
+}
+
+func (p jitter[K, V]) ExpireAfterCreate(key K, value V, now time.Time) time.Time {
+	return p.apply(now, p.policy.ExpireAfterCreate(key, value, now), time.Time{})
+}
+
+func (p jitter[K, V]) ExpireAfterUpdate(key K, value V, now, written, expires time.Time) time.Time {
+	return p.apply(now, p.policy.ExpireAfterUpdate(key, value, now, written, expires), expires)
+}
+
+func (p jitter[K, V]) ExpireAfterRead(key K, value V, now, written, expires time.Time) time.Time {
+	return p.apply(now, p.policy.ExpireAfterRead(key, value, now, written, expires), expires)
+}
+
+// expireCreated records the expiry of a newly resident entry.
+func (c *Cache[K, V]) expireCreated(e *entry[K, V]) {
+	now := c.clock.Now()
+	e.expiry = &expiryEntry[K]{key: e.key, written: now, index: -1}
+	c.setExpiry(e.expiry, c.expiry.ExpireAfterCreate(e.key, e.value, now))
+}
+
+// expireUpdated recomputes the expiry of an entry whose value was replaced.
+func (c *Cache[K, V]) expireUpdated(e *entry[K, V]) {
+	if c.expiry == nil {
+		return
+	}
+	now := c.clock.Now()
+	x := e.expiry
+	expires := c.expiry.ExpireAfterUpdate(e.key, e.value, now, x.written, x.expires)
+	x.written = now
+	c.setExpiry(x, expires)
+}
+
+// expireRead recomputes the expiry of an entry that was accessed.
+func (c *Cache[K, V]) expireRead(e *entry[K, V]) {
+	if c.expiry == nil {
+		return
+	}
+	x := e.expiry
+	c.setExpiry(x, c.expiry.ExpireAfterRead(e.key, e.value, c.clock.Now(), x.written, x.expires))
+}
+
+// setExpiry updates the expiry of e and its place in the heap.
+func (c *Cache[K, V]) setExpiry(e *expiryEntry[K], expires time.Time) {
+	if expires.Equal(e.expires) {
+		return
+	}
+	e.expires = expires
+	if e.index >= 0 {
+		if expires.IsZero() {
+			heap.Remove(&c.expiryHeap, e.index)
+		} else {
+			heap.Fix(&c.expiryHeap, e.index)
+		}
+	} else if !expires.IsZero() {
+		heap.Push(&c.expiryHeap, e)
+	}
+}
+
+// forgetExpiry drops the expiry of an entry leaving the cache.
+func (c *Cache[K, V]) forgetExpiry(e *entry[K, V]) {
+	if e.expiry.index >= 0 {
+		heap.Remove(&c.expiryHeap, e.expiry.index)
+	}
+	e.expiry = nil
+}
+
+// SetExpiry makes a cached key expire at t, or never if t is zero, without
+// reloading it, for example when the caller learns that a lease was
+// extended. The expiry policy still applies to later reads and writes,
+// so a TTI policy replaces t on the next access. SetExpiry returns false
+// if the key is not cached or the cache has no expiry policy.
+func (c *Cache[K, V]) SetExpiry(key K, t time.Time) bool {
+	if c.expiry == nil || c.applyInvalidations() != nil || c.dropStale(key) != nil {
+		return false
+	}
+	e := c.lookup(key)
+	if e == nil {
+		return false
+	}
+	c.setExpiry(e.expiry, t)
+	return true
+}
+
+// ExtendTTL makes a cached key expire d from now, as if by SetExpiry.
+func (c *Cache[K, V]) ExtendTTL(key K, d time.Duration) bool {
+	return c.SetExpiry(key, c.clock.Now().Add(d))
+}
+
+func (c *Cache[K, V]) expired(e *entry[K, V]) bool {
+	x := e.expiry
+	return x != nil && !x.expires.IsZero() && !c.clock.Now().Before(x.expires)
+}
+
+// RemoveExpired deletes every expired entry as if by Delete, returning
+// how many were deleted. Expired entries are otherwise only removed when
+// they are next touched or evicted, call this periodically to reclaim
+// them sooner. It takes time proportional to the number of expired
+// entries, not the size of the cache. Pinned entries are skipped, they
+// are renewed in place when next loaded or set.
+func (c *Cache[K, V]) RemoveExpired() (int, error) {
+	if c.expiry == nil {
+		return 0, nil
+	}
+	c.beginOp()
+	now := c.clock.Now()
+	pinned := []*expiryEntry[K]{}
+	defer func() {
+		for _, e := range pinned {
+			heap.Push(&c.expiryHeap, e)
+		}
+	}()
+	removed := 0
+	for len(c.expiryHeap) != 0 && !now.Before(c.expiryHeap[0].expires) {
+		e := c.expiryHeap[0]
+		if c.data[e.key].pins > 0 {
+			heap.Pop(&c.expiryHeap)
+			pinned = append(pinned, e)
+			continue
+		}
+		_, err := c.removeEntry(e.key, true)
+		if err != nil {
+			return removed, err
+		}
+		removed += 1
+	}
+	return removed, nil
+}
@@ -0,0 +1,54 @@
+package arc
+
+import (
+	"time"
+)
+
+// Faults injects artificial latency and failures into the cache, so
+// applications can deterministically test how they cope with a
+// misbehaving backend. It is intended for tests only.
+type Faults[K comparable] struct {
+	// LoadDelay is slept before each call to the loader.
+	LoadDelay time.Duration
+	// LoadError is called before each load, a non-nil result fails the
+	// load without calling the loader.
+	LoadError func(K) error
+	// EvictError is called before each eviction, a non-nil result fails
+	// the eviction as if OnEvict had returned it.
+	EvictError func(K) error
+}
+
+// WithFaults enables fault injection, see Faults.
+func WithFaults[K comparable, V any](faults Faults[K]) Option[K, V] {
+	return func(c *Cache[K, V]) {
+		c.faults = &faults
+	}
+}
+
+// faultyLoader wraps loader with any configured load faults.
+func (c *Cache[K, V]) faultyLoader(loader func(K) (V, error)) func(K) (V, error) {
+	if c.faults == nil {
+		return loader
+	}
+	faults := *c.faults
+	return func(key K) (V, error) {
+		if faults.LoadDelay > 0 {
+			time.Sleep(faults.LoadDelay)
+		}
+		if faults.LoadError != nil {
+			err := faults.LoadError(key)
+			if err != nil {
+				var zero V
+				return zero, err
+			}
+		}
+		return loader(key)
+	}
+}
+
+func (c *Cache[K, V]) evictFault(key K) error {
+	if c.faults == nil || c.faults.EvictError == nil {
+		return nil
+	}
+	return c.faults.EvictError(key)
+}
@@ -0,0 +1,58 @@
+package arc
+
+// BumpGeneration makes every entry currently in the cache count as a miss
+// the next time it is accessed, without walking the cache. Stale entries
+// are deleted as if by Delete when they are next touched, or evicted
+// normally if they never are. Pinned entries cannot be deleted, so a
+// stale pinned entry keeps its pins and place in the cache, and its value
+// is replaced by the next load or Set of the key.
+func (c *Cache[K, V]) BumpGeneration() {
+	c.generation += 1
+	c.victims.clear()
+}
+
+// stale reports whether a resident entry is from an older generation or
+// has expired.
+func (c *Cache[K, V]) stale(e *entry[K, V]) bool {
+	return e.born != c.generation || c.expired(e)
+}
+
+// dropStale deletes key if it is cached from an older generation or has
+// expired. Stale pinned entries are left for renew.
+func (c *Cache[K, V]) dropStale(key K) error {
+	if c.generation == 0 && c.expiry == nil {
+		return nil
+	}
+	if e, ok := c.data[key]; !ok || !c.stale(e) || e.pins > 0 {
+		return nil
+	}
+	_, err := c.removeEntry(key, true)
+	return err
+}
+
+// renew replaces the value of a stale pinned entry in place. The old value
+// leaves the cache as if it had expired, and the new one is stored as if
+// newly added.
+func (c *Cache[K, V]) renew(e *entry[K, V], value V) error {
+	key, old := e.key, e.value
+	c.recordOp("renew", key)
+	err := c.release(key, old, true)
+	if err != nil {
+		return err
+	}
+	c.listener.OnExpire(key, old)
+	c.sendEviction(key, old)
+	e.value, e.born = value, c.generation
+	if e.expiry != nil {
+		c.forgetExpiry(e)
+		c.expireCreated(e)
+	}
+	if c.trackStats {
+		c.added(e)
+	}
+	c.listener.OnAdd(key, value)
+	if c.dependents != nil {
+		return c.removeDependents(key, true)
+	}
+	return nil
+}
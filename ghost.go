@@ -0,0 +1,123 @@
+package arc
+
+// Ghosts records keys recently evicted from one of the resident lists,
+// these ghost entries are what allow ARC to adapt. The cache keeps its
+// ghost lists within its capacity using Len and RemoveOldest.
+//
+// Implementations may trade accuracy for memory, a false positive from
+// Contains only affects how the cache adapts, never what values it returns.
+type Ghosts[K comparable] interface {
+	// Add records key as the most recently evicted.
+	Add(key K)
+	// Contains reports whether key is recorded.
+	Contains(key K) bool
+	// Remove forgets key if it is recorded.
+	Remove(key K)
+	// RemoveOldest forgets the least recently evicted key.
+	RemoveOldest()
+	// Len returns the number of recorded keys.
+	Len() int
+}
+
+// WithGhosts replaces the default ghost lists, newGhosts is called once
+// for each of B1 and B2.
+func WithGhosts[K comparable, V any](newGhosts func() Ghosts[K]) Option[K, V] {
+	return func(c *Cache[K, V]) {
+		c.newGhosts = newGhosts
+	}
+}
+
+// WithoutGhosts disables the ghost lists, saving the memory of a key for
+// every recent eviction. Without ghosts the cache cannot adapt, it
+// degrades to a two list LRU where entries seen once are evicted before
+// entries seen at least twice.
+func WithoutGhosts[K comparable, V any]() Option[K, V] {
+	return WithGhosts[K, V](func() Ghosts[K] { return noGhosts[K]{} })
+}
+
+// noGhosts records nothing.
+type noGhosts[K comparable] struct{}
+
+func (noGhosts[K]) Add(K)           {}
+func (noGhosts[K]) Contains(K) bool { return false }
+func (noGhosts[K]) Remove(K)        {}
+func (noGhosts[K]) RemoveOldest()   {}
+func (noGhosts[K]) Len() int        { return 0 }
+
+// listGhosts is the default Ghosts implementation, recording full keys.
+type listGhosts[K comparable] struct {
+	*clist[K]
+}
+
+func newListGhosts[K comparable](hint int) *listGhosts[K] {
+	return &listGhosts[K]{newClist[K](hint)}
+}
+
+func (g *listGhosts[K]) Add(key K) {
+	g.PushFront(key)
+}
+
+func (g *listGhosts[K]) Contains(key K) bool {
+	return g.Has(key)
+}
+
+func (g *listGhosts[K]) Remove(key K) {
+	if elt := g.Lookup(key); elt != nil {
+		g.clist.Remove(key, elt)
+	}
+}
+
+func (g *listGhosts[K]) RemoveOldest() {
+	g.Pop()
+}
+
+// fingerprintGhosts records only a 64-bit hash of each key.
+type fingerprintGhosts[K comparable] struct {
+	hash func(K) uint64
+	l    *clist[uint64]
+}
+
+// NewFingerprintGhosts returns a Ghosts implementation that stores a 64-bit
+// fingerprint of each key instead of the key itself, for use with
+// WithGhosts. This greatly reduces ghost memory for large keys, at the cost
+// of rare hash collisions slightly skewing adaptation. A good hash for
+// strings is maphash.String with a fixed seed.
+func NewFingerprintGhosts[K comparable](hash func(K) uint64) Ghosts[K] {
+	return &fingerprintGhosts[K]{
+		hash: hash,
+		l:    newClist[uint64](0),
+	}
+}
+
+func (g *fingerprintGhosts[K]) Add(key K) {
+	fp := g.hash(key)
+	if elt := g.l.Lookup(fp); elt != nil {
+		// A collision, treat it as the same ghost.
+		g.l.MoveToFront(elt)
+		return
+	}
+	g.l.PushFront(fp)
+}
+
+func (g *fingerprintGhosts[K]) Contains(key K) bool {
+	return g.l.Has(g.hash(key))
+}
+
+func (g *fingerprintGhosts[K]) Remove(key K) {
+	fp := g.hash(key)
+	if elt := g.l.Lookup(fp); elt != nil {
+		g.l.Remove(fp, elt)
+	}
+}
+
+func (g *fingerprintGhosts[K]) RemoveOldest() {
+	g.l.Pop()
+}
+
+func (g *fingerprintGhosts[K]) Len() int {
+	return g.l.Len()
+}
+
+func (g *fingerprintGhosts[K]) Compact() {
+	g.l.Compact()
+}
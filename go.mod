@@ -1,5 +1,3 @@
 module github.com/andrewchambers/arc-go
 
 go 1.19
-
-require github.com/andrewchambers/list-go v1.0.0 // indirect
@@ -0,0 +1,84 @@
+package arc
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrNoGroups is returned by InvalidateGroup for a cache without the
+// WithGroups option.
+var ErrNoGroups = errors.New("cache has no WithGroups option")
+
+// WithGroups assigns every cached key to the group returned by groupOf, so
+// that a whole group can be removed with InvalidateGroup without scanning
+// the cache.
+func WithGroups[K comparable, V any, G comparable](groupOf func(K) G) Option[K, V] {
+	return func(c *Cache[K, V]) {
+		c.groupOf = func(key K) any { return groupOf(key) }
+		c.isGroup = func(g any) bool {
+			_, ok := g.(G)
+			return ok
+		}
+		c.groups = make(map[any]map[K]struct{})
+	}
+}
+
+// InvalidateGroup deletes every cached key in group g, as if by Delete.
+// It returns how many keys of the group were deleted, including any that
+// depended on another member; other keys deleted because they depended
+// on a member are not counted. It stops at the first error. g must have
+// the type returned by the groupOf function given to WithGroups.
+func (c *Cache[K, V]) InvalidateGroup(g any) (int, error) {
+	if c.groupOf == nil {
+		return 0, ErrNoGroups
+	}
+	if !c.isGroup(g) {
+		return 0, fmt.Errorf("group %v has type %T, not the type of the cache groups", g, g)
+	}
+	c.beginOp()
+	err := c.applyInvalidations()
+	if err != nil {
+		return 0, err
+	}
+	c.victims.dropWhere(func(key K, _ V) bool { return c.groupOf(key) == g })
+	members := c.groups[g]
+	keys := make([]K, 0, len(members))
+	for key := range members {
+		keys = append(keys, key)
+	}
+	deleted := func() int {
+		n := 0
+		for _, key := range keys {
+			if _, ok := c.data[key]; !ok {
+				n += 1
+			}
+		}
+		return n
+	}
+	for _, key := range keys {
+		_, err := c.remove(key)
+		if err != nil {
+			return deleted(), err
+		}
+	}
+	return deleted(), nil
+}
+
+func (c *Cache[K, V]) joinGroup(key K) {
+	g := c.groupOf(key)
+	members, ok := c.groups[g]
+	if !ok {
+		members = make(map[K]struct{})
+		c.groups[g] = members
+	}
+	members[key] = struct{}{}
+}
+
+func (c *Cache[K, V]) leaveGroup(key K) {
+	g := c.groupOf(key)
+	members := c.groups[g]
+	delete(members, key)
+	if len(members) == 0 {
+		delete(c.groups, g)
+	}
+}
@@ -0,0 +1,120 @@
+// Package herd simulates thundering herds against a cache, so a
+// configuration can be checked to spread out loads instead of sending
+// every request for expired keys to the origin at once.
+package herd
+
+import (
+	"math/rand"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/andrewchambers/arc-go/fakeclock"
+)
+
+// Scenario describes a flash crowd. Every key is first requested at the
+// same moment, so with a fixed lifetime they all expire together. The
+// crowd then requests random keys each Step until Duration has passed,
+// and any key that has expired is reloaded at once.
+type Scenario struct {
+	// Keys is the number of distinct keys requested.
+	Keys int
+	// RequestsPerStep is the number of requests made each step.
+	RequestsPerStep int
+	// Step is the simulated time between bursts of requests.
+	Step time.Duration
+	// Duration is how long the crowd keeps requesting.
+	Duration time.Duration
+	// Seed seeds the choice of keys, so runs are repeatable.
+	Seed int64
+}
+
+// Counter counts the loads made by a loader wrapped with Count. It is
+// safe for concurrent use.
+type Counter struct {
+	n atomic.Int64
+}
+
+// Loads returns the number of loads so far.
+func (c *Counter) Loads() int {
+	return int(c.n.Load())
+}
+
+// Count wraps a loader, such as a GetValue callback, so each call is
+// counted by c.
+func Count[K, V any](c *Counter, load func(K) (V, error)) func(K) (V, error) {
+	return func(k K) (V, error) {
+		c.n.Add(1)
+		return load(k)
+	}
+}
+
+// Result is the outcome of a run.
+type Result struct {
+	// Loads is the number of loads made in each step, the first step is
+	// the initial crowd.
+	Loads []int
+	// Errors is the number of requests that failed.
+	Errors int
+}
+
+// Total returns the number of loads over the whole run.
+func (r Result) Total() int {
+	total := 0
+	for _, n := range r.Loads {
+		total += n
+	}
+	return total
+}
+
+// Peak returns the most loads made in any step after the initial crowd,
+// the size of the worst herd.
+func (r Result) Peak() int {
+	peak := 0
+	for _, n := range r.Loads[1:] {
+		if n > peak {
+			peak = n
+		}
+	}
+	return peak
+}
+
+// Run plays the scenario, calling get for each request and advancing
+// clock, which must be the clock of the cache, between steps. Loads are
+// read from loads, which must count the loads of the cache.
+func Run(s Scenario, clock *fakeclock.Clock, loads *Counter, get func(key int) error) Result {
+	rnd := rand.New(rand.NewSource(s.Seed))
+	result := Result{}
+	step := func(keys func(i int) int, n int) {
+		before := loads.Loads()
+		for i := 0; i < n; i += 1 {
+			if get(keys(i)) != nil {
+				result.Errors += 1
+			}
+		}
+		result.Loads = append(result.Loads, loads.Loads()-before)
+	}
+	step(func(i int) int { return i }, s.Keys)
+	for elapsed := time.Duration(0); elapsed < s.Duration; elapsed += s.Step {
+		clock.Advance(s.Step)
+		step(func(int) int { return rnd.Intn(s.Keys) }, s.RequestsPerStep)
+	}
+	return result
+}
+
+// AssertPeak fails t if more than max loads were made in any step after
+// the initial crowd.
+func AssertPeak(t testing.TB, r Result, max int) {
+	t.Helper()
+	if peak := r.Peak(); peak > max {
+		t.Fatalf("herd of %d loads in one step, expected at most %d", peak, max)
+	}
+}
+
+// AssertTotal fails t if more than max loads were made over the run.
+func AssertTotal(t testing.TB, r Result, max int) {
+	t.Helper()
+	if total := r.Total(); total > max {
+		t.Fatalf("%d loads over the run, expected at most %d", total, max)
+	}
+}
@@ -0,0 +1,42 @@
+package herd
+
+import (
+	"testing"
+	"time"
+
+	arc "github.com/andrewchambers/arc-go"
+	"github.com/andrewchambers/arc-go/fakeclock"
+)
+
+func TestJitterSpreadsHerd(t *testing.T) {
+
+	scenario := Scenario{
+		Keys:            1000,
+		RequestsPerStep: 5000,
+		Step:            time.Second,
+		Duration:        2 * time.Minute,
+		Seed:            1,
+	}
+	run := func(policy arc.ExpiryPolicy[int, int]) Result {
+		clock := fakeclock.New(time.Unix(0, 0))
+		loads := &Counter{}
+		cache := arc.New(scenario.Keys, arc.Callbacks[int, int]{
+			GetValue: Count(loads, func(k int) (int, error) { return k, nil }),
+		}, arc.WithClock[int, int](clock), arc.WithExpiry(policy))
+		return Run(scenario, clock, loads, func(k int) error {
+			_, err := cache.Get(k)
+			return err
+		})
+	}
+
+	fixed := run(arc.TTL[int, int](time.Minute))
+	if fixed.Peak() < scenario.Keys*9/10 {
+		t.Fatalf("expected a fixed TTL to reload nearly every key at once, peak %d", fixed.Peak())
+	}
+	jittered := run(arc.Jitter(arc.TTL[int, int](time.Minute), 0.5))
+	AssertPeak(t, jittered, scenario.Keys/10)
+	AssertTotal(t, jittered, 4*scenario.Keys)
+	if jittered.Errors != 0 {
+		t.Fatalf("%d requests failed", jittered.Errors)
+	}
+}
@@ -0,0 +1,92 @@
+package arc
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// State is a structured snapshot of the cache internals, see Inspect.
+type State[K comparable] struct {
+	Capacity  int `json:"capacity"`
+	Partition int `json:"partition"`
+	// Each list is ordered from most to least recently used.
+	T1 []K `json:"t1"`
+	T2 []K `json:"t2"`
+	// B1 and B2 are nil if the Ghosts implementation cannot list its keys.
+	B1 []K `json:"b1"`
+	B2 []K `json:"b2"`
+}
+
+// Inspect returns a snapshot of the cache lists and adaptation state,
+// intended for tests and tooling.
+func (c *Cache[K, V]) Inspect() State[K] {
+	return State[K]{
+		Capacity:  c.cap,
+		Partition: c.part,
+		T1:        c.t1.Keys(),
+		T2:        c.t2.Keys(),
+		B1:        ghostKeys(c.b1),
+		B2:        ghostKeys(c.b2),
+	}
+}
+
+func ghostKeys[K comparable](g Ghosts[K]) []K {
+	if lister, ok := g.(interface{ Keys() []K }); ok {
+		return lister.Keys()
+	}
+	return nil
+}
+
+// DebugJSON returns the cache state and stats as JSON, suitable for
+// attaching to bug reports. It fails if the keys cannot be marshalled.
+func (c *Cache[K, V]) DebugJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		State[K]
+		Stats Stats `json:"stats"`
+	}{
+		State: c.Inspect(),
+		Stats: c.Stats(),
+	})
+}
+
+func (c *Cache[K, V]) DebugDump() string {
+	var sb strings.Builder
+
+	state := c.Inspect()
+
+	fmt.Fprintf(&sb, "Cache DebugDump:\n")
+	data := make(map[K]V, len(c.data))
+	for key, e := range c.data {
+		data[key] = e.value
+	}
+	fmt.Fprintf(&sb, "  data: %v\n", data)
+	fmt.Fprintf(&sb, "  cap: %d\n", state.Capacity)
+	fmt.Fprintf(&sb, "  part: %d\n", state.Partition)
+
+	fmt.Fprintf(&sb, "  t1:\n")
+	dumpKeys(&sb, state.T1, c.t1.Len())
+	fmt.Fprintf(&sb, "  t2:\n")
+	dumpKeys(&sb, state.T2, c.t2.Len())
+	fmt.Fprintf(&sb, "  b1:\n")
+	dumpKeys(&sb, state.B1, c.b1.Len())
+	fmt.Fprintf(&sb, "  b2:\n")
+	dumpKeys(&sb, state.B2, c.b2.Len())
+
+	return sb.String()
+}
+
+func dumpKeys[K comparable](sb *strings.Builder, keys []K, n int) {
+	if keys == nil && n != 0 {
+		fmt.Fprintf(sb, "{%d unlisted keys}", n)
+		return
+	}
+	sb.WriteString("{")
+	for i, k := range keys {
+		if i != 0 {
+			sb.WriteString(", ")
+		}
+		fmt.Fprintf(sb, "%v", k)
+	}
+	sb.WriteString("}")
+}
@@ -0,0 +1,46 @@
+package arc
+
+// InvalidateAsync queues keys to be deleted from the cache. Unlike every
+// other method it is safe to call concurrently with other use of the
+// cache, and it never blocks on cache work.
+//
+// Queued keys are deleted before the next operation that reads cached
+// entries. If a deletion fails, that operation returns the error and the
+// remaining keys stay queued. Pinned keys stay queued until unpinned.
+func (c *Cache[K, V]) InvalidateAsync(keys ...K) {
+	c.invalidateMu.Lock()
+	defer c.invalidateMu.Unlock()
+	c.invalidated = append(c.invalidated, keys...)
+	c.pendingInvalidations.Store(int32(len(c.invalidated)))
+}
+
+// applyInvalidations deletes keys queued by InvalidateAsync.
+func (c *Cache[K, V]) applyInvalidations() error {
+	if c.pendingInvalidations.Load() == 0 {
+		return nil
+	}
+
+	c.invalidateMu.Lock()
+	keys := c.invalidated
+	c.invalidated = nil
+	c.pendingInvalidations.Store(0)
+	c.invalidateMu.Unlock()
+
+	pinned := []K{}
+	for i, key := range keys {
+		_, err := c.remove(key)
+		if err == ErrPinned {
+			pinned = append(pinned, key)
+			continue
+		}
+		if err != nil {
+			c.InvalidateAsync(pinned...)
+			c.InvalidateAsync(keys[i:]...)
+			return err
+		}
+	}
+	if len(pinned) != 0 {
+		c.InvalidateAsync(pinned...)
+	}
+	return nil
+}
@@ -0,0 +1,33 @@
+//go:build go1.23
+
+package arc
+
+import (
+	"iter"
+)
+
+// Keys returns an iterator over the cached keys, see All.
+func (c *Cache[K, V]) Keys() iter.Seq[K] {
+	return func(yield func(K) bool) {
+		for k := range c.All() {
+			if !yield(k) {
+				return
+			}
+		}
+	}
+}
+
+// All returns an iterator over the cached entries in the same order as Range.
+func (c *Cache[K, V]) All() iter.Seq2[K, V] {
+	return c.Range
+}
+
+// Keys returns an iterator over the cached keys, see Cache.All.
+func (m *Manual[K, V]) Keys() iter.Seq[K] {
+	return m.c.Keys()
+}
+
+// All returns an iterator over the cached entries, see Cache.All.
+func (m *Manual[K, V]) All() iter.Seq2[K, V] {
+	return m.c.All()
+}
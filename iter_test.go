@@ -0,0 +1,45 @@
+//go:build go1.23
+
+package arc
+
+import (
+	"testing"
+)
+
+func TestIterators(t *testing.T) {
+
+	cache := NewManual[int, int](10)
+	for i := 0; i < 5; i += 1 {
+		cache.Set(i, i*10)
+	}
+	// Promote 1 to t2.
+	cache.Get(1)
+
+	keys := []int{}
+	for k := range cache.Keys() {
+		keys = append(keys, k)
+	}
+	expected := []int{1, 4, 3, 2, 0}
+	if len(keys) != len(expected) {
+		t.Fatalf("bad keys: %v", keys)
+	}
+	for i := range keys {
+		if keys[i] != expected[i] {
+			t.Fatalf("bad keys: got=%v want=%v", keys, expected)
+		}
+	}
+
+	n := 0
+	for k, v := range cache.All() {
+		if v != k*10 {
+			t.Fatalf("bad value for %d: %d", k, v)
+		}
+		n += 1
+		if n == 2 {
+			break
+		}
+	}
+	if n != 2 {
+		t.Fatal("early termination failed")
+	}
+}
@@ -0,0 +1,154 @@
+package arc
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrLoadTimeout is the error of a load that took longer than allowed by
+// WithLoadTimeout.
+var ErrLoadTimeout = errors.New("load timed out")
+
+// WithMaxConcurrentLoads bounds how many loader calls may run at once,
+// counting Get and the other lookups along with the background loads of
+// GetAsync, Prefetch, readahead and the refresher, so a cold cache cannot
+// overwhelm the backend. Loads over the limit wait for a slot.
+func WithMaxConcurrentLoads[K comparable, V any](n int) Option[K, V] {
+	return func(c *Cache[K, V]) {
+		c.maxLoads = n
+	}
+}
+
+// Limiter paces loads, a *rate.Limiter from golang.org/x/time/rate
+// satisfies it.
+type Limiter interface {
+	// Wait blocks until a load may proceed, or returns an error if it
+	// may not.
+	Wait(ctx context.Context) error
+}
+
+// WithLoadLimiter waits on limiter before every loader call, counting the
+// same loads as WithMaxConcurrentLoads, so misses respect the rate limits
+// of the backend. If Wait fails the load fails with its error. limiter
+// must be safe for concurrent use if loads run in the background.
+func WithLoadLimiter[K comparable, V any](limiter Limiter) Option[K, V] {
+	return func(c *Cache[K, V]) {
+		c.loadLimiter = limiter
+	}
+}
+
+// WithLoadTimeout fails loader calls that take longer than timeout with
+// ErrLoadTimeout, so a hung backend cannot block Get forever. Loaders take
+// no context, so a call that times out is abandoned rather than stopped:
+// it keeps running, and keeps its WithMaxConcurrentLoads slot, until it
+// returns, and its result is discarded. The loader must therefore be safe
+// for concurrent use. With WithLoadRetry each attempt has its own timeout.
+func WithLoadTimeout[K comparable, V any](timeout time.Duration) Option[K, V] {
+	return func(c *Cache[K, V]) {
+		c.loadTimeout = timeout
+	}
+}
+
+// WithStaleOnTimeout serves the cached value, without an error, when a
+// load times out under WithLoadTimeout, so a hung backend degrades to old
+// data rather than failures. An expired entry is kept in the cache while
+// its key is reloaded, and its value is returned if the load times out;
+// the entry stays expired, so the next lookup tries to load it again.
+// Refresh and lookups with ForceRefresh return the value they were
+// replacing. Lookups of keys that are not cached, or were invalidated with
+// BumpGeneration, still fail with ErrLoadTimeout.
+func WithStaleOnTimeout[K comparable, V any]() Option[K, V] {
+	return func(c *Cache[K, V]) {
+		c.staleOnTimeout = true
+	}
+}
+
+// keepForTimeout reports whether key is expired and should be kept while
+// it is reloaded, to be served if the load times out.
+func (c *Cache[K, V]) keepForTimeout(key K) bool {
+	if !c.staleOnTimeout || c.loadTimeout == 0 {
+		return false
+	}
+	e, ok := c.data[key]
+	return ok && e.born == c.generation && c.expired(e)
+}
+
+// guardLoader wraps loader with the configured load limits and retries.
+func (c *Cache[K, V]) guardLoader(loader func(K) (V, error)) func(K) (V, error) {
+	slots, limiter, retry, timeout := c.loadSlots, c.loadLimiter, c.loadRetry, c.loadTimeout
+	if slots == nil && limiter == nil && retry == nil && timeout == 0 {
+		return loader
+	}
+	load := func(key K) (V, error) {
+		if slots != nil {
+			slots <- struct{}{}
+		}
+		release := func() {
+			if slots != nil {
+				<-slots
+			}
+		}
+		if timeout == 0 {
+			defer release()
+			return loader(key)
+		}
+		return loadWithin(timeout, key, func(key K) (V, error) {
+			defer release()
+			return loader(key)
+		})
+	}
+	return func(key K) (V, error) {
+		for n := 1; ; n += 1 {
+			// Wait for the limiter first, so slots are not held while paced.
+			// Its errors are final, they are not load failures.
+			if limiter != nil {
+				err := limiter.Wait(context.Background())
+				if err != nil {
+					var zero V
+					return zero, err
+				}
+			}
+			value, err := load(key)
+			if err == nil || retry == nil || n >= retry.Attempts || !retry.retryable(err) {
+				return value, err
+			}
+			time.Sleep(retry.backoff(n))
+		}
+	}
+}
+
+// loadWithin calls loader in a new goroutine, abandoning it after timeout.
+// A panic in loader is raised again in the calling goroutine.
+func loadWithin[K comparable, V any](timeout time.Duration, key K, loader func(K) (V, error)) (V, error) {
+	type outcome struct {
+		value    V
+		err      error
+		panicked bool
+		panicV   any
+	}
+	done := make(chan outcome, 1)
+	go func() {
+		o := outcome{panicked: true}
+		defer func() {
+			if o.panicked {
+				o.panicV = recover()
+			}
+			done <- o
+		}()
+		o.value, o.err = loader(key)
+		o.panicked = false
+	}()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case o := <-done:
+		if o.panicked {
+			panic(o.panicV)
+		}
+		return o.value, o.err
+	case <-timer.C:
+		var zero V
+		return zero, ErrLoadTimeout
+	}
+}
@@ -0,0 +1,61 @@
+package arc
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// LoadRetryPolicy retries failed loads, so transient backend failures do
+// not reach the caller, see WithLoadRetry.
+type LoadRetryPolicy struct {
+	// Attempts is the maximum number of loader calls per load, including
+	// the first.
+	Attempts int
+	// Backoff is the wait after the first failure, doubling after each
+	// further failure up to MaxBackoff.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// Retryable reports whether a load error may be retried. If nil every
+	// error is retried except ErrNotFound and ErrSkipCache.
+	Retryable func(error) bool
+}
+
+func (p *LoadRetryPolicy) retryable(err error) bool {
+	if p.Retryable != nil {
+		return p.Retryable(err)
+	}
+	return !errors.Is(err, ErrNotFound) && !errors.Is(err, ErrSkipCache)
+}
+
+// WithLoadRetry retries failed loader calls according to policy. Each
+// attempt counts against WithMaxConcurrentLoads and WithLoadLimiter, but
+// a load slot is not held while waiting to retry. Like the load itself,
+// the wait blocks the caller of Get.
+func WithLoadRetry[K comparable, V any](policy LoadRetryPolicy) Option[K, V] {
+	return func(c *Cache[K, V]) {
+		c.loadRetry = &policy
+	}
+}
+
+// backoff returns the wait after the nth failed attempt.
+func (p *LoadRetryPolicy) backoff(n int) time.Duration {
+	d := p.Backoff
+	for ; n > 1 && d < p.MaxBackoff; n -= 1 {
+		d *= 2
+	}
+	if d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+	return d
+}
+
+func (p *LoadRetryPolicy) validate() error {
+	if p.Attempts < 1 {
+		return fmt.Errorf("load retry attempts must be positive, got %d", p.Attempts)
+	}
+	if p.Backoff < 0 || p.MaxBackoff < p.Backoff {
+		return fmt.Errorf("load retry backoff must not be negative or exceed the maximum, got %v and %v", p.Backoff, p.MaxBackoff)
+	}
+	return nil
+}
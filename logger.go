@@ -0,0 +1,48 @@
+package arc
+
+// Logger receives debug logs with alternating key and value arguments,
+// it is satisfied by *slog.Logger.
+type Logger interface {
+	Debug(msg string, args ...any)
+}
+
+// WithLogger logs evictions, loader errors and the resizes made by
+// AdjustCapacity at debug level.
+func WithLogger[K comparable, V any](logger Logger) Option[K, V] {
+	return func(c *Cache[K, V]) {
+		c.logger = logger
+	}
+}
+
+func (c *Cache[K, V]) logEvict(key K) {
+	if c.logger != nil {
+		c.logger.Debug("cache evict", "key", key)
+	}
+}
+
+func (c *Cache[K, V]) logEvictError(key K, err error) {
+	if c.logger != nil {
+		c.logger.Debug("cache evict failed", "key", key, "err", err)
+	}
+}
+
+func (c *Cache[K, V]) logLoadError(key K, err error) {
+	if c.logger != nil {
+		c.logger.Debug("cache load failed", "key", key, "err", err)
+	}
+}
+
+func (c *Cache[K, V]) logResize(from, to int, hitRatio float64, bytes int64, err error) {
+	if c.logger == nil {
+		return
+	}
+	args := []any{"from", from, "to", to, "hit_ratio", hitRatio}
+	if bytes > 0 {
+		args = append(args, "bytes", bytes)
+	}
+	if err != nil {
+		c.logger.Debug("cache resize failed", append(args, "err", err)...)
+		return
+	}
+	c.logger.Debug("cache resize", args...)
+}
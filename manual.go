@@ -0,0 +1,160 @@
+package arc
+
+import (
+	"errors"
+	"io"
+)
+
+var errNoLoader = errors.New("manual cache has no loader")
+
+// Manual is a cache without a GetValue callback, it is populated
+// purely with Set. Like Cache, it is NOT threadsafe.
+type Manual[K comparable, V any] struct {
+	c *Cache[K, V]
+}
+
+// NewManual creates a manual cache holding up to size entries.
+func NewManual[K comparable, V any](size int, opts ...Option[K, V]) *Manual[K, V] {
+	return &Manual[K, V]{
+		c: New(size, Callbacks[K, V]{
+			GetValue: func(K) (V, error) {
+				var zero V
+				return zero, errNoLoader
+			},
+		}, opts...),
+	}
+}
+
+// Cache returns the underlying cache, for stats, inspection and the less
+// common operations. Lookups on it that miss fail with a *LoadError, as
+// there is no loader.
+func (m *Manual[K, V]) Cache() *Cache[K, V] {
+	return m.c
+}
+
+// Get returns the value for key and true, or false if it is not cached.
+func (m *Manual[K, V]) Get(key K) (V, bool) {
+	m.c.beginOp()
+	if m.c.applyInvalidations() != nil || m.c.dropStale(key) != nil {
+		var zero V
+		return zero, false
+	}
+	e := m.c.hit(key)
+	m.c.recordLookup(e != nil)
+	if e != nil {
+		m.c.accessed(e)
+		m.c.listener.OnHit(key, e.value)
+		return e.value, true
+	}
+	m.c.listener.OnMiss(key)
+	value, ok, _ := m.c.recoverVictim(key)
+	return value, ok
+}
+
+// Peek returns the value for key and true, or false if it is not cached,
+// without counting an access.
+func (m *Manual[K, V]) Peek(key K) (V, bool) {
+	// Entries that fail to invalidate are still found.
+	_ = m.c.applyInvalidations()
+	return m.c.peek(key)
+}
+
+// Contains reports whether key is cached, without counting an access.
+func (m *Manual[K, V]) Contains(key K) bool {
+	_, ok := m.Peek(key)
+	return ok
+}
+
+// Len returns the number of cached entries, counting expired or stale
+// entries that have not been removed yet.
+func (m *Manual[K, V]) Len() int {
+	return m.c.t1.Len() + m.c.t2.Len()
+}
+
+// Set stores a value in the cache as if it had been accessed, see
+// Cache.Set. It fails if space is needed and every entry is pinned.
+func (m *Manual[K, V]) Set(key K, value V) error {
+	return m.c.Set(key, value)
+}
+
+// SetMany stores each entry in order, see Cache.SetMany.
+func (m *Manual[K, V]) SetMany(entries []KV[K, V]) (int, error) {
+	return m.c.SetMany(entries)
+}
+
+// Update replaces the value for key with the result of fn. If the key is
+// cached fn receives its value and true, otherwise the zero value and
+// false. The result is stored as if by Set. If fn returns an error the
+// cache is unchanged and the error is returned.
+func (m *Manual[K, V]) Update(key K, fn func(old V, exists bool) (V, error)) error {
+	return m.c.updateCached(key, fn)
+}
+
+// Replace stores value only if key is already cached, see Cache.Replace.
+func (m *Manual[K, V]) Replace(key K, value V) (bool, error) {
+	return m.c.Replace(key, value)
+}
+
+// Touch records an access to key, see Cache.Touch.
+func (m *Manual[K, V]) Touch(key K) bool {
+	return m.c.Touch(key)
+}
+
+// Delete removes key from the cache, see Cache.Delete.
+func (m *Manual[K, V]) Delete(key K) (bool, error) {
+	return m.c.Delete(key)
+}
+
+// DeleteWhere deletes every entry for which pred returns true, see
+// Cache.DeleteWhere.
+func (m *Manual[K, V]) DeleteWhere(pred func(K, V) bool) (int, error) {
+	return m.c.DeleteWhere(pred)
+}
+
+// Pin prevents key from being evicted, see Cache.Pin.
+func (m *Manual[K, V]) Pin(key K) bool {
+	return m.c.Pin(key)
+}
+
+// Unpin releases a pin taken by Pin.
+func (m *Manual[K, V]) Unpin(key K) {
+	m.c.Unpin(key)
+}
+
+// Range calls fn for each cached entry until fn returns false, see
+// Cache.Range.
+func (m *Manual[K, V]) Range(fn func(K, V) bool) {
+	m.c.Range(fn)
+}
+
+// AddDependency records that child is derived from parent, see
+// Cache.AddDependency.
+func (m *Manual[K, V]) AddDependency(child, parent K) bool {
+	return m.c.AddDependency(child, parent)
+}
+
+// Capacity returns the maximum number of entries held by the cache.
+func (m *Manual[K, V]) Capacity() int {
+	return m.c.Capacity()
+}
+
+// Resize changes the maximum number of entries held by the cache, see
+// Cache.Resize.
+func (m *Manual[K, V]) Resize(size int) error {
+	return m.c.Resize(size)
+}
+
+// Stats returns the cache statistics, see Cache.Stats.
+func (m *Manual[K, V]) Stats() Stats {
+	return m.c.Stats()
+}
+
+// Snapshot writes the cached entries to w, see Cache.Snapshot.
+func (m *Manual[K, V]) Snapshot(w io.Writer, keys Codec[K], values Codec[V], opts ...SnapshotOption) error {
+	return m.c.Snapshot(w, keys, values, opts...)
+}
+
+// Restore fills an empty cache from a snapshot, see Cache.Restore.
+func (m *Manual[K, V]) Restore(r io.Reader, keys Codec[K], values Codec[V], opts ...SnapshotOption) error {
+	return m.c.Restore(r, keys, values, opts...)
+}
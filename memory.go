@@ -0,0 +1,57 @@
+package arc
+
+import (
+	"unsafe"
+)
+
+// mapSlotOverhead approximates the per-slot cost of a Go map beyond its
+// key and value: the control byte plus the slack left by the load factor.
+const mapSlotOverhead = 8
+
+// WithSizer counts the memory each cached key and value point to, such as
+// string or slice contents, in EstimatedBytes. sizer returns the bytes
+// held by key and value beyond their shallow size.
+func WithSizer[K comparable, V any](sizer func(K, V) int) Option[K, V] {
+	return func(c *Cache[K, V]) {
+		c.sizer = sizer
+	}
+}
+
+// EstimatedBytes returns a rough estimate of the memory held by the cache
+// for its entries and ghosts. Keys and values are counted by their shallow
+// size, plus what WithSizer reports for resident entries, so memory they
+// point to is not included without a sizer. With a sizer the estimate
+// takes time proportional to the number of entries.
+func (c *Cache[K, V]) EstimatedBytes() int64 {
+	var (
+		k      K
+		kSize  = int64(unsafe.Sizeof(k))
+		ptr    = int64(unsafe.Sizeof(uintptr(0)))
+		node   = int64(unsafe.Sizeof(cnode[K]{}))
+		keyRef = kSize + ptr + mapSlotOverhead
+	)
+	// The entry, which is also its list node, and its slot in the map.
+	perEntry := int64(unsafe.Sizeof(entry[K, V]{})) + keyRef
+	if c.expiry != nil {
+		perEntry += int64(unsafe.Sizeof(expiryEntry[K]{}))
+	}
+	if c.trackStats {
+		perEntry += int64(unsafe.Sizeof(entryStats{}))
+	}
+	total := int64(len(c.data)) * perEntry
+	if c.sizer != nil {
+		for key, e := range c.data {
+			total += int64(c.sizer(key, e.value))
+		}
+	}
+	for _, g := range []Ghosts[K]{c.b1, c.b2} {
+		switch g.(type) {
+		case *listGhosts[K]:
+			total += int64(g.Len()) * (node + keyRef)
+		case *fingerprintGhosts[K]:
+			fpNode := int64(unsafe.Sizeof(cnode[uint64]{}))
+			total += int64(g.Len()) * (fpNode + 8 + ptr + mapSlotOverhead)
+		}
+	}
+	return total
+}
@@ -0,0 +1,57 @@
+package arc
+
+// Option configures optional cache behaviour, see New.
+type Option[K comparable, V any] func(*Cache[K, V])
+
+// WithHitRatioAlarm sets the cache Alarm.
+func WithHitRatioAlarm[K comparable, V any](alarm HitRatioAlarm) Option[K, V] {
+	return func(c *Cache[K, V]) {
+		c.Alarm = alarm
+	}
+}
+
+// WithHitRatioWindow sets roughly how many recent lookups RecentHitRatio
+// reflects, the default is 1000.
+func WithHitRatioWindow[K comparable, V any](n int) Option[K, V] {
+	return func(c *Cache[K, V]) {
+		c.recentWindow = n
+	}
+}
+
+// WithGhostRatio scales how many evicted keys the ghost lists remember,
+// relative to the cache capacity, the default is 1. Longer history lets
+// the cache adapt to patterns with longer reuse distances, shorter
+// history saves memory and adapts to recent changes faster.
+func WithGhostRatio[K comparable, V any](ratio float64) Option[K, V] {
+	return func(c *Cache[K, V]) {
+		c.ghostRatio = ratio
+	}
+}
+
+// WithVictimCache keeps the last n evicted values, so an immediate
+// re-access can be served without calling the loader. Values are kept
+// after OnEvict has been called for them, so this should only be used
+// when values remain valid after eviction.
+func WithVictimCache[K comparable, V any](n int) Option[K, V] {
+	return func(c *Cache[K, V]) {
+		c.victims.size = n
+	}
+}
+
+// WithPreallocation sizes the internal maps for a full cache up front.
+//
+// Deprecated: the maps are now always sized for a full cache, so this
+// does nothing. See WithPreallocatedEntries to also allocate the entries.
+func WithPreallocation[K comparable, V any]() Option[K, V] {
+	return func(c *Cache[K, V]) {}
+}
+
+// WithPreallocatedEntries allocates every entry and ghost list node a
+// full cache needs up front in a few large slabs, so filling the cache
+// allocates almost nothing. The memory is held even if the cache never
+// fills, so it suits caches expected to run full.
+func WithPreallocatedEntries[K comparable, V any]() Option[K, V] {
+	return func(c *Cache[K, V]) {
+		c.preallocate = true
+	}
+}
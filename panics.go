@@ -0,0 +1,72 @@
+package arc
+
+import (
+	"fmt"
+	"runtime/debug"
+)
+
+// PanicError is returned in place of a panic recovered from a callback
+// when the cache was created with WithPanicRecovery.
+type PanicError struct {
+	// Value is the value passed to panic.
+	Value any
+	// Stack is the stack trace of the panicking goroutine.
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("cache callback panicked: %v", e.Value)
+}
+
+// WithPanicRecovery recovers panics in the callbacks and loaders and
+// returns them as a *PanicError. Callbacks are called before the cache
+// is modified, so a recovered panic leaves the cache unchanged just like
+// a returned error. Only the callbacks present when the cache is created
+// are covered.
+func WithPanicRecovery[K comparable, V any]() Option[K, V] {
+	return func(c *Cache[K, V]) {
+		c.recoverPanics = true
+	}
+}
+
+// recoverCallbacks wraps each configured callback with panic recovery.
+func (c *Cache[K, V]) recoverCallbacks() {
+	cb := &c.Callbacks
+	cb.GetValue = recoverLoader(cb.GetValue)
+	cb.OnEvict = recoverAction(cb.OnEvict)
+	cb.OnExpire = recoverAction(cb.OnExpire)
+	cb.WriteValue = recoverAction(cb.WriteValue)
+	cb.SetValue = recoverAction(cb.SetValue)
+	if verify := cb.Verify; verify != nil {
+		cb.Verify = func(key K, value V) (ok bool, err error) {
+			defer catchPanic(&err)
+			return verify(key, value)
+		}
+	}
+}
+
+func recoverLoader[K comparable, V any](f func(K) (V, error)) func(K) (V, error) {
+	if f == nil {
+		return nil
+	}
+	return func(key K) (value V, err error) {
+		defer catchPanic(&err)
+		return f(key)
+	}
+}
+
+func recoverAction[K comparable, V any](f func(K, V) error) func(K, V) error {
+	if f == nil {
+		return nil
+	}
+	return func(key K, value V) (err error) {
+		defer catchPanic(&err)
+		return f(key, value)
+	}
+}
+
+func catchPanic(err *error) {
+	if v := recover(); v != nil {
+		*err = &PanicError{Value: v, Stack: debug.Stack()}
+	}
+}
@@ -0,0 +1,152 @@
+package arc
+
+import (
+	"errors"
+	"sync"
+)
+
+// WithReadahead hints the keys returned by next whenever a lookup misses,
+// so they are loaded in the background by StartReadahead. It suits keys
+// read in order, such as the blocks of a file, where next returns the
+// blocks that follow.
+func WithReadahead[K comparable, V any](next func(K) []K) Option[K, V] {
+	return func(c *Cache[K, V]) {
+		c.readahead = next
+	}
+}
+
+// Hint queues keys to be loaded in the background by StartReadahead if
+// they are not already cached. Like InvalidateAsync it is safe to call
+// concurrently with other use of the cache and never blocks. Hints beyond
+// the capacity of the cache are dropped, as they could not all be kept.
+func (c *Cache[K, V]) Hint(keys ...K) {
+	c.hintMu.Lock()
+	defer c.hintMu.Unlock()
+	n := min(len(keys), c.hintCap-len(c.hints))
+	if n <= 0 {
+		return
+	}
+	c.hints = append(c.hints, keys[:n]...)
+	select {
+	case c.hinted <- struct{}{}:
+	default:
+	}
+}
+
+// nextHint removes and returns the oldest queued hint.
+func (c *Cache[K, V]) nextHint() (K, bool) {
+	c.hintMu.Lock()
+	defer c.hintMu.Unlock()
+	if len(c.hints) == 0 {
+		var zero K
+		return zero, false
+	}
+	key := c.hints[0]
+	c.hints = c.hints[1:]
+	if len(c.hints) == 0 {
+		c.hints = nil
+	}
+	return key, true
+}
+
+// ReadaheadConfig configures StartReadahead.
+type ReadaheadConfig[K comparable] struct {
+	// Locker guards all use of the cache, it must be the lock the owner
+	// of the cache holds. It is never held while loading.
+	Locker sync.Locker
+	// Parallelism is how many hinted keys may be loaded at once, 1 if
+	// zero.
+	Parallelism int
+	// OnError, if set, is called without the lock held when a hinted key
+	// fails to load or be cached.
+	OnError func(K, error)
+}
+
+// StartReadahead starts goroutines that load keys queued by Hint with
+// GetValue and cache them as if by Warm. GetValue must be safe for
+// concurrent use with the cache owner. At most one readahead may run at a
+// time.
+//
+// The returned function stops the readahead and waits for it to exit,
+// hints still queued are kept.
+func (c *Cache[K, V]) StartReadahead(cfg ReadaheadConfig[K]) (stop func()) {
+	if cfg.Locker == nil {
+		panic("expected a readahead Locker")
+	}
+	if cfg.Parallelism < 1 {
+		cfg.Parallelism = 1
+	}
+	hinted := c.hinted
+	done := make(chan struct{})
+	wg := sync.WaitGroup{}
+	for i := 0; i < cfg.Parallelism; i += 1 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				key, ok := c.nextHint()
+				if !ok {
+					select {
+					case <-done:
+						return
+					case <-hinted:
+					}
+					continue
+				}
+				// Pass the wakeup on in case more hints are queued.
+				select {
+				case hinted <- struct{}{}:
+				default:
+				}
+				err := c.readKeyAhead(cfg.Locker, key)
+				if err != nil && cfg.OnError != nil {
+					cfg.OnError(key, err)
+				}
+				select {
+				case <-done:
+					return
+				default:
+				}
+			}
+		}()
+	}
+	return func() {
+		close(done)
+		wg.Wait()
+	}
+}
+
+// readKeyAhead loads and caches a hinted key unless it is already cached.
+func (c *Cache[K, V]) readKeyAhead(locker sync.Locker, key K) error {
+	locker.Lock()
+	err := c.applyInvalidations()
+	if err == nil {
+		err = c.dropStale(key)
+	}
+	resident := c.lookup(key) != nil
+	getValue := c.guardLoader(c.faultyLoader(c.Callbacks.GetValue))
+	locker.Unlock()
+	if err != nil || resident {
+		return err
+	}
+
+	value, err := getValue(key)
+	if errors.Is(err, ErrSkipCache) {
+		return nil
+	}
+	if err != nil {
+		return &LoadError[K]{Key: key, Err: err}
+	}
+
+	locker.Lock()
+	defer locker.Unlock()
+	c.beginOp()
+	if e, ok := c.data[key]; ok {
+		if c.stale(e) {
+			return c.renew(e, value)
+		}
+		// Cached by the owner while loading, its value is newer.
+		return nil
+	}
+	return c.admit(key, value)
+}
@@ -0,0 +1,133 @@
+package arc
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// RefresherConfig configures StartRefresher.
+type RefresherConfig[K comparable] struct {
+	// Interval is the time between the start of each pass over the cache.
+	Interval time.Duration
+	// Locker guards all use of the cache, it must be the lock the owner
+	// of the cache holds. It is only held to list the keys and to store
+	// each reloaded value, never while loading.
+	Locker sync.Locker
+	// MaxLoadsPerSecond, if positive, spaces out loads so the backend is
+	// not hit with every key at once.
+	MaxLoadsPerSecond int
+	// OnError, if set, is called without the lock held when a reload
+	// fails, or when deleting an entry the loader skipped fails. The
+	// cached value is left in place.
+	OnError func(K, error)
+}
+
+// StartRefresher starts a goroutine that reloads every resident entry
+// with GetValue each interval, so the cache acts as a self-updating view
+// of slowly changing data. GetValue must be safe for concurrent use with
+// the cache owner, and is subject to the same load limits and injected
+// faults as Get. An entry whose reload returns ErrSkipCache is deleted,
+// as the origin no longer wants it cached. Entries keep their position
+// in the cache. Dirty
+// entries, set but not yet written back, are newer than the origin and
+// are left alone. Otherwise a value set while its key is being reloaded
+// may be overwritten by the reload.
+//
+// The returned function stops the refresher and waits for it to exit.
+func (c *Cache[K, V]) StartRefresher(cfg RefresherConfig[K]) (stop func()) {
+	if cfg.Interval <= 0 {
+		panic("refresher interval must be positive")
+	}
+	if cfg.Locker == nil {
+		panic("expected a refresher Locker")
+	}
+	done := make(chan struct{})
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(cfg.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				c.refreshAll(cfg, done)
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		wg.Wait()
+	}
+}
+
+func (c *Cache[K, V]) refreshAll(cfg RefresherConfig[K], done chan struct{}) {
+	cfg.Locker.Lock()
+	keys := []K{}
+	c.Range(func(key K, _ V) bool {
+		keys = append(keys, key)
+		return true
+	})
+	getValue := c.guardLoader(c.faultyLoader(c.Callbacks.GetValue))
+	cfg.Locker.Unlock()
+
+	var gap time.Duration
+	if cfg.MaxLoadsPerSecond > 0 {
+		gap = time.Second / time.Duration(cfg.MaxLoadsPerSecond)
+	}
+	for i, key := range keys {
+		if i != 0 && gap > 0 {
+			select {
+			case <-done:
+				return
+			case <-time.After(gap):
+			}
+		}
+		select {
+		case <-done:
+			return
+		default:
+		}
+		value, err := getValue(key)
+		if errors.Is(err, ErrSkipCache) {
+			cfg.Locker.Lock()
+			err = c.refreshSkipped(key)
+			cfg.Locker.Unlock()
+		} else if err == nil {
+			cfg.Locker.Lock()
+			c.refreshed(key, value)
+			cfg.Locker.Unlock()
+		}
+		if err != nil && cfg.OnError != nil {
+			cfg.OnError(key, err)
+		}
+	}
+}
+
+// refreshSkipped deletes key after its reload returned ErrSkipCache,
+// unless it is dirty or pinned.
+func (c *Cache[K, V]) refreshSkipped(key K) error {
+	e, ok := c.data[key]
+	if !ok || e.dirty || e.pins > 0 {
+		return nil
+	}
+	c.beginOp()
+	_, err := c.remove(key)
+	return err
+}
+
+// refreshed replaces the value of key if it is still resident and clean.
+func (c *Cache[K, V]) refreshed(key K, value V) {
+	e, ok := c.data[key]
+	if !ok || e.dirty || c.stale(e) {
+		return
+	}
+	c.beginOp()
+	old := e.value
+	e.value = value
+	c.expireUpdated(e)
+	c.listener.OnUpdate(key, old, value)
+}
@@ -0,0 +1,125 @@
+package arc
+
+import (
+	"fmt"
+)
+
+// ReplicationOp is the kind of a ReplicationEvent.
+type ReplicationOp int
+
+const (
+	// ReplicaSet stores the value of the event.
+	ReplicaSet ReplicationOp = iota
+	// ReplicaDelete removes the key of the event.
+	ReplicaDelete
+)
+
+// ReplicationEvent is a change to the resident entries of a cache, see
+// ReplicationFeed.
+type ReplicationEvent[K comparable, V any] struct {
+	// Seq numbers events from 1 without gaps.
+	Seq   uint64
+	Op    ReplicationOp
+	Key   K
+	Value V
+}
+
+// ReplicationFeed is an EventListener turning the activity of a primary
+// cache into an ordered stream of events, so a standby Replica can hold
+// roughly the same entries and take over with a warm cache. Adds and
+// updates are sent as sets, and evictions, deletions and expiries as
+// deletes, so the standby mirrors what is resident in the primary.
+//
+// Events are delivered on a buffered channel. The primary never blocks on
+// a slow consumer: when the buffer is full the event is dropped, and the
+// Replica reports the gap in the sequence numbers.
+type ReplicationFeed[K comparable, V any] struct {
+	NopListener[K, V]
+	seq    uint64
+	events chan ReplicationEvent[K, V]
+}
+
+// NewReplicationFeed creates a feed buffering up to buffer events, attach
+// it to the primary with WithEventListener.
+func NewReplicationFeed[K comparable, V any](buffer int) *ReplicationFeed[K, V] {
+	return &ReplicationFeed[K, V]{events: make(chan ReplicationEvent[K, V], buffer)}
+}
+
+// Events returns the channel events are delivered on.
+func (f *ReplicationFeed[K, V]) Events() <-chan ReplicationEvent[K, V] {
+	return f.events
+}
+
+func (f *ReplicationFeed[K, V]) send(op ReplicationOp, key K, value V) {
+	f.seq += 1
+	select {
+	case f.events <- ReplicationEvent[K, V]{Seq: f.seq, Op: op, Key: key, Value: value}:
+	default:
+	}
+}
+
+func (f *ReplicationFeed[K, V]) OnAdd(key K, value V)     { f.send(ReplicaSet, key, value) }
+func (f *ReplicationFeed[K, V]) OnUpdate(key K, _, new V) { f.send(ReplicaSet, key, new) }
+func (f *ReplicationFeed[K, V]) OnEvict(key K, value V)   { f.send(ReplicaDelete, key, value) }
+func (f *ReplicationFeed[K, V]) OnExpire(key K, value V)  { f.send(ReplicaDelete, key, value) }
+
+// ReplicationGapError is returned by Replica.Apply when events were lost
+// between the last event applied and this one.
+type ReplicationGapError struct {
+	// Expected is the sequence number that should have come next, Got is
+	// the one that did.
+	Expected uint64
+	Got      uint64
+}
+
+func (e *ReplicationGapError) Error() string {
+	return fmt.Sprintf("replication gap: expected event %d, got %d", e.Expected, e.Got)
+}
+
+// Replica applies the events of a ReplicationFeed to a standby cache. The
+// standby should have no SetValue or WriteValue callbacks, as the primary
+// has already written its values.
+type Replica[K comparable, V any] struct {
+	c    *Cache[K, V]
+	next uint64
+}
+
+// NewReplica returns a Replica applying events to c, starting from the
+// first event of a feed.
+func NewReplica[K comparable, V any](c *Cache[K, V]) *Replica[K, V] {
+	return &Replica[K, V]{c: c, next: 1}
+}
+
+// Apply applies ev to the standby cache. Events older than the last one
+// applied are ignored. If events were skipped ev is still applied, and a
+// *ReplicationGapError is returned so the caller can resync the standby,
+// for example by restoring a Snapshot of the primary.
+func (r *Replica[K, V]) Apply(ev ReplicationEvent[K, V]) error {
+	if ev.Seq < r.next {
+		return nil
+	}
+	var err error
+	switch ev.Op {
+	case ReplicaSet:
+		err = r.c.Set(ev.Key, ev.Value)
+	case ReplicaDelete:
+		_, err = r.c.Delete(ev.Key)
+	default:
+		err = fmt.Errorf("unknown replication op %d", ev.Op)
+	}
+	if err != nil {
+		return err
+	}
+	expected := r.next
+	r.next = ev.Seq + 1
+	if ev.Seq != expected {
+		return &ReplicationGapError{Expected: expected, Got: ev.Seq}
+	}
+	return nil
+}
+
+// Resync makes the replica expect seq next, after the standby has been
+// brought up to date with the primary as of the event before it.
+func (r *Replica[K, V]) Resync(seq uint64) {
+	r.next = seq
+}
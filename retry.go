@@ -0,0 +1,157 @@
+package arc
+
+import (
+	"fmt"
+	"time"
+)
+
+// EvictErrorPolicy decides what happens when OnEvict returns an error.
+type EvictErrorPolicy int
+
+const (
+	// EvictErrorFail fails the operation that needed the eviction and
+	// leaves the entry cached. This is the default.
+	EvictErrorFail EvictErrorPolicy = iota
+	// EvictErrorIgnore evicts the entry anyway, the error is only logged.
+	EvictErrorIgnore
+	// EvictErrorRetry evicts the entry anyway and queues the OnEvict call
+	// to be retried, see WithEvictionRetry.
+	EvictErrorRetry
+)
+
+// Default backoff used by EvictErrorRetry without WithEvictionRetry.
+const (
+	defaultRetryBackoff    = time.Second
+	defaultRetryMaxBackoff = time.Minute
+)
+
+// WithEvictErrorPolicy sets how OnEvict errors are handled, see
+// EvictErrorPolicy.
+func WithEvictErrorPolicy[K comparable, V any](policy EvictErrorPolicy) Option[K, V] {
+	return func(c *Cache[K, V]) {
+		c.evictPolicy = policy
+	}
+}
+
+// evictionRetries holds evictions whose OnEvict call failed.
+type evictionRetries[K comparable, V any] struct {
+	backoff    time.Duration
+	maxBackoff time.Duration
+	pending    []pendingEviction[K, V]
+}
+
+type pendingEviction[K comparable, V any] struct {
+	key      K
+	value    V
+	expired  bool
+	attempts int
+	next     time.Time
+}
+
+// WithEvictionRetry selects EvictErrorRetry with the given backoff, so
+// evictions succeed even when OnEvict fails. The failed call is queued
+// and retried by RetryEvictions, waiting backoff after the first failure
+// and doubling the wait after each further failure, up to maxBackoff.
+// Write-back failures still fail the eviction, as the value would
+// otherwise be lost.
+func WithEvictionRetry[K comparable, V any](backoff, maxBackoff time.Duration) Option[K, V] {
+	return func(c *Cache[K, V]) {
+		c.evictPolicy = EvictErrorRetry
+		c.retries = &evictionRetries[K, V]{
+			backoff:    backoff,
+			maxBackoff: maxBackoff,
+		}
+	}
+}
+
+// notifyEvict calls OnEvict, or OnExpire, for a value leaving the cache.
+func (c *Cache[K, V]) notifyEvict(key K, value V, expired bool) error {
+	err := c.evictFault(key)
+	if err != nil {
+		return err
+	}
+	if expired && c.Callbacks.OnExpire != nil {
+		return c.Callbacks.OnExpire(key, value)
+	}
+	return c.Callbacks.OnEvict(key, value)
+}
+
+// evictFailed handles a failed OnEvict call according to the policy,
+// it returns an error if the eviction must fail.
+func (c *Cache[K, V]) evictFailed(key K, value V, expired bool, err error) error {
+	if c.evictPolicy == EvictErrorIgnore {
+		c.logEvictError(key, err)
+		return nil
+	}
+	if c.evictPolicy != EvictErrorRetry {
+		return err
+	}
+	r := c.retries
+	r.pending = append(r.pending, pendingEviction[K, V]{
+		key:      key,
+		value:    value,
+		expired:  expired,
+		attempts: 1,
+		next:     c.clock.Now().Add(r.backoff),
+	})
+	return nil
+}
+
+// RetryEvictions retries the queued OnEvict calls that are due, returning
+// how many remain queued and the last error seen. The cache does not
+// retry by itself, the owner is expected to call this periodically.
+func (c *Cache[K, V]) RetryEvictions() (int, error) {
+	return c.retryEvictions(false)
+}
+
+func (c *Cache[K, V]) retryEvictions(all bool) (int, error) {
+	r := c.retries
+	if r == nil || c.evictPolicy != EvictErrorRetry {
+		return 0, nil
+	}
+	var lastErr error
+	now := c.clock.Now()
+	remaining := r.pending[:0]
+	for _, p := range r.pending {
+		if !all && now.Before(p.next) {
+			remaining = append(remaining, p)
+			continue
+		}
+		err := c.notifyEvict(p.key, p.value, p.expired)
+		if err == nil {
+			continue
+		}
+		lastErr = err
+		wait := r.backoff
+		for i := 0; i < p.attempts && wait < r.maxBackoff; i += 1 {
+			wait *= 2
+		}
+		if wait > r.maxBackoff {
+			wait = r.maxBackoff
+		}
+		p.attempts += 1
+		p.next = now.Add(wait)
+		remaining = append(remaining, p)
+	}
+	// Clear the tail so dropped values can be collected.
+	for i := len(remaining); i < len(r.pending); i += 1 {
+		r.pending[i] = pendingEviction[K, V]{}
+	}
+	r.pending = remaining
+	return len(r.pending), lastErr
+}
+
+// Close makes a final attempt at every queued eviction regardless of
+// backoff, and closes the eviction channel if there is one. It returns an
+// error if any evictions could not be completed. The cache must not be
+// used after Close.
+func (c *Cache[K, V]) Close() error {
+	n, err := c.retryEvictions(true)
+	if c.evictions != nil {
+		close(c.evictions)
+	}
+	if err != nil {
+		return fmt.Errorf("%d evictions could not be completed: %w", n, err)
+	}
+	return nil
+}
@@ -0,0 +1,381 @@
+package arc
+
+import (
+	"bufio"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+// A snapshot starts with snapshotMagic, the format version as a uvarint
+// and a flags byte. Version 1 continues with a nonce if the snapshot is
+// encrypted, then a sequence of frames. Each frame is a uvarint length,
+// the frame data and the CRC-32C of the data, big endian. When encrypted
+// the data of each frame is sealed with the AEAD, using the nonce with
+// the frame number added to its last eight bytes.
+//
+// The first frame holds the partition target and the capacity of the
+// cache, each as a uvarint. It is followed
+// by a frame for each entry and a frame holding just a zero byte. Each
+// entry frame is the list it is in, 1 for T1 or 2 for T2, followed by the
+// encoded key and value, each as a uvarint length and the bytes. Entries
+// are written from least to most recently used within each list.
+//
+// The format only changes by adding versions. Restore keeps reading every
+// version written by earlier releases, so a snapshot taken before an
+// upgrade can be restored after it.
+const snapshotVersion = 1
+
+var snapshotMagic = [4]byte{'A', 'R', 'C', 'S'}
+
+// snapshotEncrypted is set in the flags of an encrypted snapshot.
+const snapshotEncrypted byte = 1 << 0
+
+// maxSnapshotRecord bounds the length of a frame, key or value read by
+// Restore, so a corrupt length cannot exhaust memory.
+const maxSnapshotRecord = 1 << 30
+
+const (
+	snapshotEnd byte = iota
+	snapshotT1
+	snapshotT2
+)
+
+var snapshotTable = crc32.MakeTable(crc32.Castagnoli)
+
+// ErrSnapshotCorrupt is returned, wrapped, by Restore for a snapshot that
+// fails its checksums or authentication, or is otherwise malformed.
+var ErrSnapshotCorrupt = errors.New("corrupt snapshot")
+
+// SnapshotVersion returns the version of the format written by Snapshot.
+// Restore reads this version and every earlier one.
+func SnapshotVersion() int {
+	return snapshotVersion
+}
+
+// SnapshotVersionError is returned by Restore for a snapshot written in a
+// newer format than this release can read.
+type SnapshotVersionError struct {
+	Version int
+}
+
+func (e *SnapshotVersionError) Error() string {
+	return fmt.Sprintf("snapshot format version %d is newer than supported version %d", e.Version, snapshotVersion)
+}
+
+// SnapshotOption configures Snapshot and Restore.
+type SnapshotOption func(*snapshotConfig)
+
+type snapshotConfig struct {
+	aead cipher.AEAD
+}
+
+// WithSnapshotAEAD encrypts and authenticates a snapshot with aead, such
+// as AES-GCM, so it can be stored where others can read it. Restore must
+// be given an AEAD with the same key. The nonce size must be at least 8.
+func WithSnapshotAEAD(aead cipher.AEAD) SnapshotOption {
+	return func(cfg *snapshotConfig) {
+		cfg.aead = aead
+	}
+}
+
+// snapshotFrames reads and writes the frames of a version 1 snapshot.
+type snapshotFrames struct {
+	aead  cipher.AEAD
+	nonce []byte
+	n     uint64
+}
+
+// nextNonce returns the nonce of the next frame.
+func (f *snapshotFrames) nextNonce() []byte {
+	nonce := append([]byte{}, f.nonce...)
+	tail := nonce[len(nonce)-8:]
+	binary.BigEndian.PutUint64(tail, binary.BigEndian.Uint64(tail)+f.n)
+	f.n += 1
+	return nonce
+}
+
+func (f *snapshotFrames) write(w *bufio.Writer, data []byte) {
+	if f.aead != nil {
+		data = f.aead.Seal(nil, f.nextNonce(), data, nil)
+	}
+	writeBytes(w, data)
+	var sum [4]byte
+	binary.BigEndian.PutUint32(sum[:], crc32.Checksum(data, snapshotTable))
+	w.Write(sum[:])
+}
+
+func (f *snapshotFrames) read(r *bufio.Reader) ([]byte, error) {
+	data, err := readBytes(r)
+	if err != nil {
+		return nil, err
+	}
+	var sum [4]byte
+	_, err = io.ReadFull(r, sum[:])
+	if err != nil {
+		return nil, err
+	}
+	if binary.BigEndian.Uint32(sum[:]) != crc32.Checksum(data, snapshotTable) {
+		return nil, fmt.Errorf("%w: checksum mismatch", ErrSnapshotCorrupt)
+	}
+	if f.aead != nil {
+		data, err = f.aead.Open(data[:0], f.nextNonce(), data, nil)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrSnapshotCorrupt, err)
+		}
+	}
+	return data, nil
+}
+
+// Snapshot writes the resident entries and their positions to w, encoding
+// keys and values with the given codecs, so the cache can be rebuilt with
+// Restore after a restart. Stale entries are skipped. Pins, dirty flags,
+// expiry times, stats and the ghost lists are not saved, so flush a
+// write-back cache first. Each record is checksummed, and the snapshot
+// is encrypted if WithSnapshotAEAD is given.
+func (c *Cache[K, V]) Snapshot(w io.Writer, keys Codec[K], values Codec[V], opts ...SnapshotOption) error {
+	cfg := snapshotConfig{}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	frames := &snapshotFrames{aead: cfg.aead}
+	flags := byte(0)
+	if cfg.aead != nil {
+		if cfg.aead.NonceSize() < 8 {
+			return errors.New("snapshot AEAD nonce must be at least 8 bytes")
+		}
+		flags |= snapshotEncrypted
+		frames.nonce = make([]byte, cfg.aead.NonceSize())
+		_, err := rand.Read(frames.nonce)
+		if err != nil {
+			return err
+		}
+	}
+	bw := bufio.NewWriter(w)
+	bw.Write(snapshotMagic[:])
+	writeUvarint(bw, snapshotVersion)
+	bw.WriteByte(flags)
+	bw.Write(frames.nonce)
+	frames.write(bw, binary.AppendUvarint(binary.AppendUvarint(nil, uint64(c.part)), uint64(c.cap)))
+	record := []byte{}
+	for _, l := range [...]struct {
+		kind byte
+		list *entryList[K, V]
+	}{{snapshotT1, c.t1}, {snapshotT2, c.t2}} {
+		for e := l.list.Back(); e != nil; e = e.preceding() {
+			if c.stale(e) {
+				continue
+			}
+			k, err := keys.Encode(e.key)
+			if err != nil {
+				return err
+			}
+			v, err := values.Encode(e.value)
+			if err != nil {
+				return err
+			}
+			record = append(record[:0], l.kind)
+			record = binary.AppendUvarint(record, uint64(len(k)))
+			record = append(record, k...)
+			record = binary.AppendUvarint(record, uint64(len(v)))
+			record = append(record, v...)
+			frames.write(bw, record)
+		}
+	}
+	frames.write(bw, []byte{snapshotEnd})
+	return bw.Flush()
+}
+
+// Restore fills an empty cache from a snapshot written by Snapshot with
+// the same codecs and options. If the snapshot holds more entries than
+// fit, the least recently used are dropped, recently used entries before
+// frequently used ones. Restored entries are clean and their expiry starts
+// afresh. A snapshot that is truncated, fails a checksum or fails to
+// decrypt is rejected with an error wrapping ErrSnapshotCorrupt. On error
+// the cache is left empty.
+func (c *Cache[K, V]) Restore(r io.Reader, keys Codec[K], values Codec[V], opts ...SnapshotOption) error {
+	if len(c.data) != 0 {
+		return errors.New("can only restore into an empty cache")
+	}
+	c.beginOp()
+	cfg := snapshotConfig{}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	br := bufio.NewReader(r)
+	var magic [4]byte
+	_, err := io.ReadFull(br, magic[:])
+	if err != nil || magic != snapshotMagic {
+		return errors.New("not a cache snapshot")
+	}
+	version, err := binary.ReadUvarint(br)
+	if err != nil {
+		return fmt.Errorf("reading snapshot: %w", err)
+	}
+	switch version {
+	case 1:
+		err = c.restoreV1(br, keys, values, cfg)
+	default:
+		if version > snapshotVersion {
+			return &SnapshotVersionError{Version: int(version)}
+		}
+		return fmt.Errorf("unknown snapshot format version %d", version)
+	}
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		err = fmt.Errorf("%w: truncated", ErrSnapshotCorrupt)
+	}
+	if err != nil {
+		return fmt.Errorf("reading snapshot: %w", err)
+	}
+	return nil
+}
+
+func (c *Cache[K, V]) restoreV1(r *bufio.Reader, keys Codec[K], values Codec[V], cfg snapshotConfig) error {
+	flags, err := r.ReadByte()
+	if err != nil {
+		return err
+	}
+	if flags&^snapshotEncrypted != 0 {
+		return fmt.Errorf("unknown snapshot flags %#x", flags)
+	}
+	frames := &snapshotFrames{}
+	if flags&snapshotEncrypted != 0 {
+		if cfg.aead == nil {
+			return errors.New("snapshot is encrypted, expected an AEAD")
+		}
+		frames.aead = cfg.aead
+		frames.nonce = make([]byte, cfg.aead.NonceSize())
+		_, err = io.ReadFull(r, frames.nonce)
+		if err != nil {
+			return err
+		}
+	} else if cfg.aead != nil {
+		return errors.New("snapshot is not encrypted")
+	}
+
+	frame, err := frames.read(r)
+	if err != nil {
+		return err
+	}
+	part, n := binary.Uvarint(frame)
+	if n <= 0 {
+		return fmt.Errorf("%w: bad partition", ErrSnapshotCorrupt)
+	}
+	size, m := binary.Uvarint(frame[n:])
+	if m <= 0 || n+m != len(frame) {
+		return fmt.Errorf("%w: bad capacity", ErrSnapshotCorrupt)
+	}
+	// The partition target of a cache is at most its capacity.
+	if part > size {
+		return fmt.Errorf("%w: partition %d exceeds capacity %d", ErrSnapshotCorrupt, part, size)
+	}
+	var t1, t2 []KV[K, V]
+	for {
+		frame, err := frames.read(r)
+		if err != nil {
+			return err
+		}
+		if len(frame) == 0 {
+			return fmt.Errorf("%w: empty record", ErrSnapshotCorrupt)
+		}
+		kind := frame[0]
+		if kind == snapshotEnd {
+			break
+		}
+		if kind != snapshotT1 && kind != snapshotT2 {
+			return fmt.Errorf("unknown record type %d", kind)
+		}
+		k, rest, ok := cutBytes(frame[1:])
+		if !ok {
+			return fmt.Errorf("%w: bad key", ErrSnapshotCorrupt)
+		}
+		v, rest, ok := cutBytes(rest)
+		if !ok || len(rest) != 0 {
+			return fmt.Errorf("%w: bad value", ErrSnapshotCorrupt)
+		}
+		kv := KV[K, V]{}
+		kv.Key, err = keys.Decode(k)
+		if err != nil {
+			return err
+		}
+		kv.Value, err = values.Decode(v)
+		if err != nil {
+			return err
+		}
+		if kind == snapshotT1 {
+			t1 = append(t1, kv)
+		} else {
+			t2 = append(t2, kv)
+		}
+		if uint64(len(t1)+len(t2)) > size {
+			return fmt.Errorf("%w: more entries than capacity %d", ErrSnapshotCorrupt, size)
+		}
+	}
+
+	// Records are oldest first, drop the oldest that do not fit.
+	over := len(t1) + len(t2) - c.cap
+	if over > 0 {
+		n := min(over, len(t1))
+		t1, over = t1[n:], over-n
+		t2 = t2[over:]
+	}
+	// A partition from a larger cache is clamped to this one.
+	c.part = c.cap
+	if part < uint64(c.cap) {
+		c.part = int(part)
+	}
+	for _, l := range [...]struct {
+		list    *entryList[K, V]
+		entries []KV[K, V]
+	}{{c.t1, t1}, {c.t2, t2}} {
+		for _, kv := range l.entries {
+			if _, ok := c.data[kv.Key]; ok {
+				continue
+			}
+			c.b1.Remove(kv.Key)
+			c.b2.Remove(kv.Key)
+			c.store(l.list, kv.Key, kv.Value)
+		}
+	}
+	c.trimGhosts()
+	return nil
+}
+
+func writeUvarint(w *bufio.Writer, x uint64) {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], x)
+	w.Write(buf[:n])
+}
+
+func writeBytes(w *bufio.Writer, b []byte) {
+	writeUvarint(w, uint64(len(b)))
+	w.Write(b)
+}
+
+func readBytes(r *bufio.Reader) ([]byte, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	if n > maxSnapshotRecord {
+		return nil, fmt.Errorf("%w: record of %d bytes is too large", ErrSnapshotCorrupt, n)
+	}
+	b := make([]byte, n)
+	_, err = io.ReadFull(r, b)
+	return b, err
+}
+
+// cutBytes splits a uvarint length prefixed byte string from the front
+// of b.
+func cutBytes(b []byte) ([]byte, []byte, bool) {
+	n, size := binary.Uvarint(b)
+	if size <= 0 || n > uint64(len(b)-size) {
+		return nil, nil, false
+	}
+	b = b[size:]
+	return b[:n], b[n:], true
+}
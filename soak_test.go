@@ -0,0 +1,174 @@
+//go:build soak
+
+package arc
+
+import (
+	"errors"
+	"flag"
+	"math/rand"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+)
+
+var soakDuration = flag.Duration("soak.duration", 2*time.Minute, "how long to run the soak test")
+
+// TestSoak runs a random mix of operations for a long time, alongside the
+// refresher, readahead and invalidations from another goroutine,
+// periodically checking invariants, goroutine counts and heap growth. Run
+// it with:
+//
+//	go test -tags soak -race -run Soak -soak.duration 10m
+func TestSoak(t *testing.T) {
+
+	const cacheSize = 1000
+	const keySpace = 5000
+
+	origin := make(map[int]int)
+
+	cache := New(cacheSize, Callbacks[int, int]{
+		GetValue: func(k int) (int, error) {
+			if rand.Float64() < 0.01 {
+				return 0, errors.New("GetValue failed")
+			}
+			return k, nil
+		},
+		OnEvict: func(k, v int) error {
+			if rand.Float64() < 0.01 {
+				return errors.New("Evict failed")
+			}
+			return nil
+		},
+		WriteValue: func(k, v int) error {
+			if rand.Float64() < 0.01 {
+				return errors.New("WriteValue failed")
+			}
+			origin[k] = v
+			return nil
+		},
+	},
+		WithVictimCache[int, int](16),
+		WithGroups[int, int](func(k int) int { return k % 10 }),
+	)
+
+	warmKeys := make([]int, 100)
+
+	mu := &sync.Mutex{}
+	stopRefresher := cache.StartRefresher(RefresherConfig[int]{
+		Interval:          100 * time.Millisecond,
+		Locker:            mu,
+		MaxLoadsPerSecond: 10000,
+	})
+	defer stopRefresher()
+	stopReadahead := cache.StartReadahead(ReadaheadConfig[int]{
+		Locker:      mu,
+		Parallelism: 4,
+	})
+	defer stopReadahead()
+	done := make(chan struct{})
+	invalidator := sync.WaitGroup{}
+	invalidator.Add(1)
+	go func() {
+		defer invalidator.Done()
+		for {
+			select {
+			case <-done:
+				return
+			case <-time.After(100 * time.Microsecond):
+				cache.InvalidateAsync(rand.Intn(keySpace))
+			}
+		}
+	}()
+	defer func() {
+		close(done)
+		invalidator.Wait()
+	}()
+
+	// Results of GetAsync not yet received, drained before goroutines are
+	// counted.
+	pending := []<-chan Result[int]{}
+	drain := func() {
+		for _, ch := range pending {
+			<-ch
+		}
+		pending = pending[:0]
+	}
+	defer drain()
+
+	baseGoroutines := runtime.NumGoroutine()
+	var baseHeap uint64
+	deadline := time.Now().Add(*soakDuration)
+	// The first check, which also records the baseline heap, is taken
+	// once the cache has had time to fill.
+	nextCheck := time.Now().Add(5 * time.Second)
+
+	for i := 0; time.Now().Before(deadline); i += 1 {
+		k := rand.Intn(keySpace)
+		mu.Lock()
+		switch op := rand.Intn(100); {
+		case op < 55:
+			cache.Get(k)
+		case op < 57:
+			pending = append(pending, cache.GetAsync(k, mu))
+		case op < 60:
+			cache.Hint(k)
+		case op < 75:
+			cache.Set(k, k)
+		case op < 80:
+			cache.Delete(k)
+		case op < 83:
+			if cache.Pin(k) {
+				cache.Unpin(k)
+			}
+		case op < 86:
+			cache.InvalidateAsync(k)
+		case op < 88:
+			cache.Flush()
+		case op < 89:
+			cache.Trim(10)
+		case op < 90:
+			cache.InvalidateGroup(k % 10)
+		default:
+			if i%1000 == 0 {
+				for j := range warmKeys {
+					warmKeys[j] = rand.Intn(keySpace)
+				}
+				cache.Warm(warmKeys, 4)
+			}
+			if i%100000 == 0 {
+				cache.BumpGeneration()
+				cache.Compact()
+			}
+		}
+		mu.Unlock()
+		if len(pending) >= 64 {
+			drain()
+		}
+
+		if time.Now().Before(nextCheck) {
+			continue
+		}
+		nextCheck = time.Now().Add(5 * time.Second)
+
+		drain()
+		mu.Lock()
+		checkInvariants(t, cache)
+		stats := cache.Stats()
+		mu.Unlock()
+
+		if n := runtime.NumGoroutine(); n > baseGoroutines {
+			t.Fatalf("goroutine leak: %d goroutines, started with %d", n, baseGoroutines)
+		}
+
+		var m runtime.MemStats
+		runtime.GC()
+		runtime.ReadMemStats(&m)
+		if baseHeap == 0 {
+			baseHeap = m.HeapAlloc
+		} else if m.HeapAlloc > 4*baseHeap+(1<<20) {
+			t.Fatalf("heap grew from %d to %d bytes", baseHeap, m.HeapAlloc)
+		}
+		t.Logf("ops=%d heap=%d stats=%+v", i, m.HeapAlloc, stats)
+	}
+}
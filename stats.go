@@ -0,0 +1,137 @@
+package arc
+
+const defaultHitRatioWindow = 1000
+
+// Stats holds cache lookup counters.
+type Stats struct {
+	Hits   uint64 `json:"hits"`
+	Misses uint64 `json:"misses"`
+	// B1Hits and B2Hits count admissions of keys remembered in the ghost
+	// lists. Many B1 hits mean the workload favours recency, many B2 hits
+	// frequency, and a high total suggests the cache is too small.
+	B1Hits uint64 `json:"b1_hits"`
+	B2Hits uint64 `json:"b2_hits"`
+}
+
+// HitRatio returns the fraction of lookups that were hits,
+// or zero if there have been no lookups.
+func (s Stats) HitRatio() float64 {
+	total := s.Hits + s.Misses
+	if total == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(total)
+}
+
+// HitRatioAlarm configures a callback fired when the cache hit ratio stays
+// below an acceptable level.
+type HitRatioAlarm struct {
+	// MinRatio is the lowest acceptable hit ratio for a window.
+	MinRatio float64
+	// Window is the number of lookups in each window.
+	Window int
+	// Windows is how many consecutive windows must fall below MinRatio
+	// before OnDegraded is called.
+	Windows int
+	// OnDegraded is called with the stats of the window that tripped the alarm.
+	OnDegraded func(Stats)
+}
+
+// Stats returns the lookup counters since the cache was created.
+func (c *Cache[K, V]) Stats() Stats {
+	return c.stats
+}
+
+// RecentHitRatio returns the hit ratio of recent lookups, weighting each
+// lookup less the older it is. Unlike Stats it reflects a workload shift
+// quickly, see WithHitRatioWindow. It is zero if there have been no
+// lookups.
+func (c *Cache[K, V]) RecentHitRatio() float64 {
+	if c.recentWeight == 0 {
+		return 0
+	}
+	return c.recentHits / c.recentWeight
+}
+
+// T1Len returns the number of entries seen once recently.
+func (c *Cache[K, V]) T1Len() int {
+	return c.t1.Len()
+}
+
+// T2Len returns the number of entries seen at least twice recently.
+func (c *Cache[K, V]) T2Len() int {
+	return c.t2.Len()
+}
+
+// B1Len returns the number of ghost keys recently evicted from T1.
+func (c *Cache[K, V]) B1Len() int {
+	return c.b1.Len()
+}
+
+// B2Len returns the number of ghost keys recently evicted from T2.
+func (c *Cache[K, V]) B2Len() int {
+	return c.b2.Len()
+}
+
+// Partition returns the adaptive target size for T1. A high value means
+// the cache currently favours recency, a low value frequency.
+func (c *Cache[K, V]) Partition() int {
+	return c.part
+}
+
+// HighWater returns the peak number of resident entries since the cache
+// was created, and since the last call to ResetHighWater.
+func (c *Cache[K, V]) HighWater() (total, sinceReset int) {
+	return c.peak, c.peakSinceReset
+}
+
+// ResetHighWater restarts the since-reset high-water mark from the
+// current number of entries.
+func (c *Cache[K, V]) ResetHighWater() {
+	c.peakSinceReset = len(c.data)
+}
+
+func (c *Cache[K, V]) recordLen() {
+	n := len(c.data)
+	if n > c.peak {
+		c.peak = n
+	}
+	if n > c.peakSinceReset {
+		c.peakSinceReset = n
+	}
+}
+
+func (c *Cache[K, V]) recordLookup(hit bool) {
+	// Exponentially weighted averages, the weight corrects the bias
+	// towards zero before many lookups have been seen.
+	decay := 1 - c.recentAlpha
+	c.recentHits *= decay
+	c.recentWeight = c.recentWeight*decay + c.recentAlpha
+	if hit {
+		c.stats.Hits += 1
+		c.window.Hits += 1
+		c.recentHits += c.recentAlpha
+	} else {
+		c.stats.Misses += 1
+		c.window.Misses += 1
+	}
+
+	alarm := &c.Alarm
+	if alarm.OnDegraded == nil || alarm.Window <= 0 {
+		return
+	}
+	if c.window.Hits+c.window.Misses < uint64(alarm.Window) {
+		return
+	}
+	window := c.window
+	c.window = Stats{}
+	if window.HitRatio() >= alarm.MinRatio {
+		c.degradedWindows = 0
+		return
+	}
+	c.degradedWindows += 1
+	if c.degradedWindows >= max(alarm.Windows, 1) {
+		c.degradedWindows = 0
+		alarm.OnDegraded(window)
+	}
+}
@@ -0,0 +1,120 @@
+package arc
+
+import (
+	"errors"
+)
+
+// L2 is a second level cache behind a Tiered cache, such as Redis,
+// memcached or a disk store.
+type L2[K comparable, V any] interface {
+	// Get returns the value for key and true, or false if it is not held.
+	Get(key K) (V, bool, error)
+	Set(key K, value V) error
+	Delete(key K) error
+}
+
+// TierWritePolicy decides when values set in a Tiered cache reach L2.
+type TierWritePolicy int
+
+const (
+	// TierWriteThrough writes values to L2 as they are set, a failed
+	// write fails the Set. This is the default.
+	TierWriteThrough TierWritePolicy = iota
+	// TierWriteBack writes values to L2 when they leave the cache or are
+	// flushed.
+	TierWriteBack
+)
+
+// TieredConfig describes a cache to be created by NewTiered.
+type TieredConfig[K comparable, V any] struct {
+	// Size is the maximum number of entries held in memory.
+	Size int
+	L2   L2[K, V]
+	// GetValue loads keys missing from both tiers, it is not stored in L2
+	// until it is set or demoted.
+	GetValue    func(K) (V, error)
+	WritePolicy TierWritePolicy
+	// Demote writes values evicted from memory to make space to L2, so
+	// they are found there rather than reloaded. Deleted, invalidated and
+	// expired values are not demoted. A failed demotion fails the eviction.
+	Demote  bool
+	Options []Option[K, V]
+}
+
+// Tiered layers an in-memory cache over an L2 cache. Misses are looked up
+// in L2 before GetValue is called. Like Cache, it is NOT threadsafe.
+type Tiered[K comparable, V any] struct {
+	c  *Cache[K, V]
+	l2 L2[K, V]
+}
+
+// NewTiered creates a tiered cache, returning an error describing the
+// problem if the configuration is invalid.
+func NewTiered[K comparable, V any](cfg TieredConfig[K, V]) (*Tiered[K, V], error) {
+	if cfg.L2 == nil {
+		return nil, errors.New("expected an L2 cache")
+	}
+	if cfg.GetValue == nil {
+		return nil, errors.New("expected a GetValue callback")
+	}
+	l2, getValue := cfg.L2, cfg.GetValue
+	callbacks := Callbacks[K, V]{
+		GetValue: func(key K) (V, error) {
+			value, ok, err := l2.Get(key)
+			if err != nil || ok {
+				return value, err
+			}
+			return getValue(key)
+		},
+	}
+	switch cfg.WritePolicy {
+	case TierWriteThrough:
+		callbacks.SetValue = l2.Set
+	case TierWriteBack:
+		callbacks.WriteValue = l2.Set
+	default:
+		return nil, errors.New("unknown tier write policy")
+	}
+	c, err := NewWithConfig(Config[K, V]{
+		Size:      cfg.Size,
+		Callbacks: callbacks,
+		Options:   cfg.Options,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Demote {
+		c.demote = l2.Set
+	}
+	return &Tiered[K, V]{c: c, l2: l2}, nil
+}
+
+// L1 returns the in-memory cache, for stats and inspection. Using it
+// directly behaves as using t, except that its Delete leaves L2 alone.
+func (t *Tiered[K, V]) L1() *Cache[K, V] {
+	return t.c
+}
+
+// Get returns the value for key from memory, then L2, then GetValue.
+func (t *Tiered[K, V]) Get(key K) (V, error) {
+	return t.c.Get(key)
+}
+
+// Set stores value in memory and in L2 according to the write policy.
+func (t *Tiered[K, V]) Set(key K, value V) error {
+	return t.c.Set(key, value)
+}
+
+// Delete removes key from both tiers.
+func (t *Tiered[K, V]) Delete(key K) error {
+	_, err := t.c.Delete(key)
+	if err != nil {
+		return err
+	}
+	return t.l2.Delete(key)
+}
+
+// Flush writes every value not yet written back to L2.
+func (t *Tiered[K, V]) Flush() error {
+	return t.c.Flush()
+}
@@ -0,0 +1,114 @@
+package arc
+
+import (
+	"time"
+)
+
+// victimCache is a small FIFO of recently evicted entries.
+type victimCache[K comparable, V any] struct {
+	entries []victim[K, V]
+	size    int
+}
+
+// victim is an evicted entry, with the generation and expiry it had so a
+// value that has since gone stale is not recovered.
+type victim[K comparable, V any] struct {
+	key     K
+	value   V
+	born    uint64
+	written time.Time
+	expires time.Time
+}
+
+func (v *victimCache[K, V]) push(x victim[K, V]) {
+	if v.size == 0 {
+		return
+	}
+	if v.entries == nil {
+		v.entries = make([]victim[K, V], 0, v.size)
+	}
+	if len(v.entries) == v.size {
+		copy(v.entries, v.entries[1:])
+		v.entries = v.entries[:len(v.entries)-1]
+	}
+	v.entries = append(v.entries, x)
+}
+
+// take removes key from the victim cache, returning its entry.
+func (v *victimCache[K, V]) take(key K) (victim[K, V], bool) {
+	for i := range v.entries {
+		if v.entries[i].key == key {
+			x := v.entries[i]
+			v.removeAt(i)
+			return x, true
+		}
+	}
+	return victim[K, V]{}, false
+}
+
+// dropWhere removes every entry for which pred returns true.
+func (v *victimCache[K, V]) dropWhere(pred func(K, V) bool) {
+	for i := 0; i < len(v.entries); {
+		if pred(v.entries[i].key, v.entries[i].value) {
+			v.removeAt(i)
+		} else {
+			i += 1
+		}
+	}
+}
+
+// clear removes every entry.
+func (v *victimCache[K, V]) clear() {
+	for i := range v.entries {
+		v.entries[i] = victim[K, V]{}
+	}
+	v.entries = v.entries[:0]
+}
+
+func (v *victimCache[K, V]) removeAt(i int) {
+	copy(v.entries[i:], v.entries[i+1:])
+	v.entries[len(v.entries)-1] = victim[K, V]{}
+	v.entries = v.entries[:len(v.entries)-1]
+}
+
+// pushVictim keeps an entry being evicted in the victim cache, it must be
+// called before the entry is unstored.
+func (c *Cache[K, V]) pushVictim(e *entry[K, V]) {
+	if c.victims.size == 0 {
+		return
+	}
+	x := victim[K, V]{key: e.key, value: e.value, born: e.born}
+	if e.expiry != nil {
+		x.written, x.expires = e.expiry.written, e.expiry.expires
+	}
+	c.victims.push(x)
+}
+
+// recoverVictim moves key from the victim cache back into the cache with
+// the expiry it had. A stale pinned entry is still resident, so it is not
+// recovered over, and a victim from an older generation or that has
+// expired is dropped.
+func (c *Cache[K, V]) recoverVictim(key K) (V, bool, error) {
+	var zero V
+	if _, ok := c.data[key]; ok {
+		return zero, false, nil
+	}
+	x, ok := c.victims.take(key)
+	if !ok {
+		return zero, false, nil
+	}
+	if x.born != c.generation || (!x.expires.IsZero() && !c.clock.Now().Before(x.expires)) {
+		return zero, false, nil
+	}
+	err := c.admit(key, x.value)
+	if err != nil {
+		// Rollback removal.
+		c.victims.push(x)
+		return zero, false, err
+	}
+	if e := c.data[key]; e.expiry != nil {
+		e.expiry.written = x.written
+		c.setExpiry(e.expiry, x.expires)
+	}
+	return x.value, true, nil
+}
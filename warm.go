@@ -0,0 +1,128 @@
+package arc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// WarmError is returned by Warm when some keys could not be loaded,
+// it records the failure for each such key.
+type WarmError[K comparable] struct {
+	Errors map[K]error
+}
+
+func (e *WarmError[K]) Error() string {
+	return fmt.Sprintf("failed to warm %d keys", len(e.Errors))
+}
+
+// Warm loads keys that are not already in the cache, calling GetValue
+// from up to parallelism goroutines at once. GetValue must be safe for
+// concurrent use when parallelism is greater than one.
+//
+// A failure to load or insert one key does not stop the others, instead
+// all failures are reported together in a *WarmError.
+func (c *Cache[K, V]) Warm(keys []K, parallelism int) error {
+	return c.Prefetch(context.Background(), keys, parallelism)
+}
+
+// Prefetch is like Warm, but stops starting new loads once ctx is done,
+// returning its error. Loads already running are still waited for and
+// cached.
+func (c *Cache[K, V]) Prefetch(ctx context.Context, keys []K, parallelism int) error {
+	if parallelism < 1 {
+		parallelism = 1
+	}
+	op := c.beginOp()
+	err := c.applyInvalidations()
+	if err != nil {
+		return err
+	}
+
+	// Decide what to load before starting any goroutines, the cache
+	// itself is only touched from this goroutine.
+	failed := make(map[K]error)
+	pending := make([]K, 0, len(keys))
+	seen := make(map[K]struct{}, len(keys))
+	for _, key := range keys {
+		if _, ok := seen[key]; ok {
+			continue
+		}
+		seen[key] = struct{}{}
+		err := c.dropStale(key)
+		if err != nil {
+			failed[key] = err
+			continue
+		}
+		if c.lookup(key) != nil {
+			continue
+		}
+		pending = append(pending, key)
+	}
+
+	type loaded struct {
+		key   K
+		value V
+		err   error
+	}
+
+	getValue := c.guardLoader(c.faultyLoader(c.Callbacks.GetValue))
+	work := make(chan K)
+	results := make(chan loaded)
+	wg := sync.WaitGroup{}
+
+	for i := 0; i < parallelism; i += 1 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for key := range work {
+				value, err := getValue(key)
+				results <- loaded{key: key, value: value, err: err}
+			}
+		}()
+	}
+
+	go func() {
+		defer func() {
+			close(work)
+			wg.Wait()
+			close(results)
+		}()
+		for _, key := range pending {
+			select {
+			case work <- key:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	for r := range results {
+		c.opID = op
+		err := r.err
+		if errors.Is(err, ErrSkipCache) {
+			continue
+		}
+		if err != nil {
+			c.logLoadError(r.key, err)
+			err = &LoadError[K]{Key: r.key, Err: err}
+		} else if e, ok := c.data[r.key]; ok {
+			// Stale but pinned.
+			err = c.renew(e, r.value)
+		} else {
+			err = c.admit(r.key, r.value)
+		}
+		if err != nil {
+			failed[r.key] = err
+		}
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if len(failed) != 0 {
+		return &WarmError[K]{Errors: failed}
+	}
+	return nil
+}